@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 	"unsafe"
 
 	"github.com/asticode/go-astiav"
@@ -12,9 +14,11 @@ import (
 type AudioTrack struct {
 	StreamIndex int
 	Language    string
+	Title       string
 	Codec       string
 	Channels    int
 	SampleRate  int
+	Duration    time.Duration // length of the file; 0 = unknown
 }
 
 // ProbeAudioTracks opens a media file and returns metadata for each audio stream.
@@ -33,6 +37,12 @@ func ProbeAudioTracks(path string) ([]AudioTrack, error) {
 		return nil, fmt.Errorf("finding stream info: %w", err)
 	}
 
+	// The container's duration is in AV_TIME_BASE units (microseconds).
+	var duration time.Duration
+	if d := fc.Duration(); d > 0 {
+		duration = time.Duration(d) * time.Microsecond
+	}
+
 	var tracks []AudioTrack
 	for _, s := range fc.Streams() {
 		cp := s.CodecParameters()
@@ -40,40 +50,92 @@ func ProbeAudioTracks(path string) ([]AudioTrack, error) {
 			continue
 		}
 
-		lang := ""
+		lang, title := "", ""
 		if md := s.Metadata(); md != nil {
 			if entry := md.Get("language", nil, 0); entry != nil {
 				lang = entry.Value()
 			}
+			if entry := md.Get("title", nil, 0); entry != nil {
+				title = entry.Value()
+			}
 		}
 
 		tracks = append(tracks, AudioTrack{
 			StreamIndex: s.Index(),
 			Language:    lang,
+			Title:       title,
 			Codec:       cp.CodecID().Name(),
 			Channels:    cp.ChannelLayout().Channels(),
 			SampleRate:  cp.SampleRate(),
+			Duration:    duration,
 		})
 	}
 
 	return tracks, nil
 }
 
+// IsMediaFile reports whether libavformat recognises the file's content as
+// a container with at least one audio stream, regardless of its extension.
+// Stream info is only probed for formats that declare no streams up front
+// (e.g. MPEG-TS), so this stays cheap for most files. FFmpeg's logging is
+// silenced meanwhile: files that are not media are the expected case here.
+func IsMediaFile(path string) bool {
+	defer astiav.SetLogLevel(astiav.GetLogLevel())
+	astiav.SetLogLevel(astiav.LogLevelQuiet)
+
+	fc := astiav.AllocFormatContext()
+	if fc == nil {
+		return false
+	}
+	defer fc.CloseInput()
+
+	if err := fc.OpenInput(path, nil, nil); err != nil {
+		return false
+	}
+
+	if len(fc.Streams()) == 0 {
+		if err := fc.FindStreamInfo(nil); err != nil {
+			return false
+		}
+	}
+
+	for _, s := range fc.Streams() {
+		if s.CodecParameters().MediaType() == astiav.MediaTypeAudio {
+			return true
+		}
+	}
+	return false
+}
+
 // ExtractAudio decodes the specified audio stream and resamples it to
 // 16 kHz mono float32, suitable for speech recognition models.
-func ExtractAudio(path string, streamIndex int) ([]float32, error) {
+//
+// Cancelling ctx stops decoding between packets and interrupts blocking
+// reads (e.g. from stalled network storage); ctx.Err() is then returned.
+func ExtractAudio(ctx context.Context, path string, streamIndex int) ([]float32, error) {
 	// Open input
 	fc := astiav.AllocFormatContext()
 	if fc == nil {
 		return nil, fmt.Errorf("allocating format context")
 	}
+	ii := astiav.NewIOInterrupter()
+	defer ii.Free()
 	defer fc.CloseInput()
+	fc.SetIOInterrupter(ii)
+	stop := context.AfterFunc(ctx, ii.Interrupt)
+	defer stop()
 
 	if err := fc.OpenInput(path, nil, nil); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("opening %q: %w", path, err)
 	}
 
 	if err := fc.FindStreamInfo(nil); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("finding stream info: %w", err)
 	}
 
@@ -173,11 +235,17 @@ func ExtractAudio(path string, streamIndex int) ([]float32, error) {
 	var samples []float32
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		err := fc.ReadFrame(pkt)
 		if err != nil {
 			if errors.Is(err, astiav.ErrEof) {
 				break
 			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, fmt.Errorf("reading frame: %w", err)
 		}
 
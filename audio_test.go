@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"testing"
 )
 
@@ -54,7 +56,7 @@ func TestExtractAudio(t *testing.T) {
 		t.Fatal("no audio tracks found for extraction test")
 	}
 
-	samples, err := ExtractAudio(testVideoPath, tracks[0].StreamIndex)
+	samples, err := ExtractAudio(context.Background(), testVideoPath, tracks[0].StreamIndex)
 	if err != nil {
 		t.Fatalf("ExtractAudio returned error: %v", err)
 	}
@@ -75,7 +77,7 @@ func TestExtractAudio_HasNonZeroSamples(t *testing.T) {
 		t.Fatal("no audio tracks found for extraction test")
 	}
 
-	samples, err := ExtractAudio(testVideoPath, tracks[0].StreamIndex)
+	samples, err := ExtractAudio(context.Background(), testVideoPath, tracks[0].StreamIndex)
 	if err != nil {
 		t.Fatalf("ExtractAudio returned error: %v", err)
 	}
@@ -94,3 +96,29 @@ func TestExtractAudio_HasNonZeroSamples(t *testing.T) {
 	pct := float64(nonZero) / float64(len(samples)) * 100
 	t.Logf("%d/%d samples are non-zero (%.1f%%)", nonZero, len(samples), pct)
 }
+
+func TestExtractAudio_Cancelled(t *testing.T) {
+	tracks, err := ProbeAudioTracks(testVideoPath)
+	if err != nil || len(tracks) == 0 {
+		t.Fatalf("ProbeAudioTracks: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ExtractAudio(ctx, testVideoPath, tracks[0].StreamIndex)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ExtractAudio with cancelled context: err = %v; want context.Canceled", err)
+	}
+}
+
+func TestIsMediaFile(t *testing.T) {
+	if !IsMediaFile(testVideoPath) {
+		t.Errorf("IsMediaFile(%q) = false; want true", testVideoPath)
+	}
+	if IsMediaFile("test/videos/fragment.rus.srt") {
+		t.Error("IsMediaFile on a subtitle file = true; want false")
+	}
+	if IsMediaFile("/nonexistent/file.bin") {
+		t.Error("IsMediaFile on a missing file = true; want false")
+	}
+}
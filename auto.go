@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// autoModel is the --model value that picks a model for the run.
+const autoModel = "auto"
+
+// defaultTargetRTF is the transcription time --model auto aims for, as a
+// multiple of the audio duration, when neither --target-rtf nor --deadline
+// is given.
+const defaultTargetRTF = 0.25
+
+// autoSurveyLimit is how many files --model auto probes for their
+// duration and language; larger batches are extrapolated from them.
+const autoSurveyLimit = 200
+
+// modelProfile is what --model auto knows about a built-in model.
+type modelProfile struct {
+	name   string
+	memory int64   // memory needed to run it, in bytes
+	cost   float64 // CPU seconds per second of audio, roughly, on a recent x86 core
+}
+
+// autoProfiles lists the models --model auto chooses from, worst to best.
+// The English-only variants replace the multilingual ones when all audio
+// is known to be English. The figures are estimates from whisper.cpp
+// benchmarks; they only need to be right in proportion.
+var autoProfiles = []modelProfile{
+	{"tiny", 273 << 20, 0.16},
+	{"base", 388 << 20, 0.32},
+	{"small", 852 << 20, 0.8},
+	{"medium", 2100 << 20, 2.0},
+	{"turbo", 2000 << 20, 2.4},
+	{"large", 3900 << 20, 4.0},
+}
+
+// englishModels maps multilingual models to their English-only variants,
+// which are faster and more accurate on English.
+var englishModels = map[string]string{
+	"tiny":   "tiny.en",
+	"base":   "base.en",
+	"small":  "small.en",
+	"medium": "medium.en",
+}
+
+// gpuSpeedup is how much faster whisper.cpp runs on Apple's Metal GPU than
+// on the CPU cores alone.
+const gpuSpeedup = 4
+
+// AutoInput describes the run --model auto chooses a model for.
+type AutoInput struct {
+	English   bool          // all audio is known to be English
+	Audio     time.Duration // total duration of the audio; 0 = unknown
+	CPUs      int
+	GPU       bool  // Metal is available
+	Memory    int64 // available memory in bytes; 0 = unknown
+	TargetRTF float64
+	Deadline  time.Duration // limit for the whole run; 0 = use TargetRTF
+
+	// Cached restricts the choice to these models, e.g. those in the cache
+	// with --offline; nil = any.
+	Cached map[string]bool
+}
+
+// AutoChoice is the model --model auto picked, and why.
+type AutoChoice struct {
+	Model    string
+	RTF      float64       // estimated transcription time / audio duration
+	Estimate time.Duration // estimated time for the run; 0 = unknown
+	Fits     bool          // the estimate meets the target
+}
+
+// ChooseModel picks the most accurate model that fits in memory and is
+// estimated to meet the deadline, or else the target real-time factor.
+// If none does, it picks the fastest. For English, only English-only
+// models are considered, unless none of them is usable.
+func ChooseModel(in AutoInput) AutoChoice {
+	target := in.TargetRTF
+	if in.Deadline > 0 && in.Audio > 0 {
+		target = float64(in.Deadline) / float64(in.Audio)
+	}
+	if target <= 0 {
+		target = defaultTargetRTF
+	}
+
+	// English audio gets an English-only model whenever one can be used:
+	// turbo and large, which have no such variant, are then left out.
+	englishOnly := false
+	if in.English {
+		for _, en := range englishModels {
+			if in.usable(en) {
+				englishOnly = true
+			}
+		}
+	}
+
+	choice := AutoChoice{Model: autoProfiles[0].name}
+	found := false
+	for _, p := range autoProfiles {
+		name := p.name
+		if en := englishModels[name]; in.English && en != "" && in.usable(en) {
+			name = en
+		}
+		if !in.usable(name) || (englishOnly && !strings.HasSuffix(name, ".en")) {
+			continue
+		}
+		rtf := estimatedRTF(p, in)
+		fits := rtf <= target && (in.Memory == 0 || p.memory <= in.Memory*3/4)
+		if fits || !found {
+			choice = AutoChoice{Model: name, RTF: rtf, Fits: fits}
+			found = true
+		}
+	}
+	if in.Audio > 0 {
+		choice.Estimate = time.Duration(choice.RTF * float64(in.Audio))
+	}
+	return choice
+}
+
+// usable reports whether the model can be chosen.
+func (in AutoInput) usable(name string) bool {
+	return in.Cached == nil || in.Cached[name]
+}
+
+// estimatedRTF estimates the real-time factor of a model on the machine.
+// Beyond 16 threads whisper.cpp hardly gets faster.
+func estimatedRTF(p modelProfile, in AutoInput) float64 {
+	rtf := p.cost / float64(min(max(in.CPUs, 1), 16))
+	if in.GPU {
+		rtf /= gpuSpeedup
+	}
+	return rtf
+}
+
+// surveyFiles probes the files a run will transcribe for --model auto: the
+// total duration of their audio, and whether all of it is known to be
+// English, from the language settings or trusted track tags. Audio is not
+// sampled for its language, so without either it counts as not English. Only the first
+// autoSurveyLimit files are probed; the total is extrapolated from them.
+func surveyFiles(files []MediaFile, cfg Config) (total time.Duration, english bool) {
+	english = !cfg.CodeSwitching
+	probed := files[:min(len(files), autoSurveyLimit)]
+	for _, f := range probed {
+		lang, stream := cfg.Language, cfg.AudioTrack
+		if o := f.Options; o != nil {
+			if o.Language != "" {
+				lang = o.Language
+			}
+			if o.AudioTrack >= 0 {
+				stream = o.AudioTrack
+			}
+		}
+
+		tracks, err := probeTracks(f.Path)
+		if err != nil {
+			english = english && lang == "en"
+			continue
+		}
+		var length time.Duration
+		for _, t := range tracks {
+			if stream >= 0 && t.StreamIndex != stream {
+				continue
+			}
+			length = max(length, t.Duration)
+			if lang == "" {
+				// Untrusted tags are only a guess; detection decides.
+				tagLang, _ := WhisperLanguage(t.Language)
+				english = english && cfg.TrustTrackLanguage && tagLang == "en"
+			}
+		}
+		if lang != "" {
+			english = english && lang == "en"
+		}
+		total += length
+	}
+	if len(probed) > 0 && len(files) > len(probed) {
+		total = total / time.Duration(len(probed)) * time.Duration(len(files))
+	}
+	return total, english
+}
+
+// machineInput fills in the hardware part of an AutoInput.
+func machineInput(in AutoInput) AutoInput {
+	in.CPUs = runtime.NumCPU()
+	in.GPU = runtime.GOOS == "darwin"
+	in.Memory = availableMemory()
+	return in
+}
+
+// describe explains the choice for the run's header, e.g.
+// "small.en (English, 3h12m00s of audio, 8 CPUs, 15.6 GB free: about 38m24s)".
+func (c AutoChoice) describe(in AutoInput) string {
+	var facts []string
+	if in.English {
+		facts = append(facts, "English")
+	}
+	if in.Audio > 0 {
+		facts = append(facts, formatDuration(in.Audio.Seconds())+" of audio")
+	}
+	hw := fmt.Sprintf("%d CPUs", in.CPUs)
+	if in.GPU {
+		hw += " + Metal"
+	}
+	facts = append(facts, hw)
+	if in.Memory > 0 {
+		facts = append(facts, formatSize(in.Memory)+" free")
+	}
+
+	estimate := fmt.Sprintf("about %.2fx real time", c.RTF)
+	if c.Estimate > 0 {
+		estimate = "about " + formatDuration(c.Estimate.Seconds())
+	}
+	if !c.Fits {
+		estimate += "; no model meets the target, using the smallest"
+	}
+	return fmt.Sprintf("%s (%s: %s)", c.Model, strings.Join(facts, ", "), estimate)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChooseModel(t *testing.T) {
+	const gb = 1 << 30
+	tests := []struct {
+		name string
+		in   AutoInput
+		want string
+		fits bool
+	}{
+		{"8 cores", AutoInput{CPUs: 8, Memory: 16 * gb}, "medium", true},
+		{"8 cores, English", AutoInput{CPUs: 8, Memory: 16 * gb, English: true}, "medium.en", true},
+		{"12 cores", AutoInput{CPUs: 12, Memory: 16 * gb}, "turbo", true},
+		{"12 cores, English", AutoInput{CPUs: 12, Memory: 16 * gb, English: true}, "medium.en", true},
+		{"Metal", AutoInput{CPUs: 8, GPU: true, Memory: 16 * gb}, "large", true},
+		{"Metal, English", AutoInput{CPUs: 8, GPU: true, Memory: 16 * gb, English: true}, "medium.en", true},
+		{"little memory", AutoInput{CPUs: 8, Memory: 1 * gb}, "base", true},
+		{"relaxed target, 4 GB", AutoInput{CPUs: 8, TargetRTF: 1, Memory: 4 * gb}, "turbo", true},
+		{"relaxed target", AutoInput{CPUs: 8, TargetRTF: 1}, "large", true},
+		{"deadline", AutoInput{CPUs: 8, Audio: 10 * time.Hour, Deadline: time.Hour}, "small", true},
+		{"too slow", AutoInput{CPUs: 1, TargetRTF: 0.01, English: true}, "tiny.en", false},
+		{"offline cache", AutoInput{CPUs: 8, English: true, Cached: map[string]bool{"small": true, "turbo": true}}, "small", true},
+	}
+	for _, tt := range tests {
+		got := ChooseModel(tt.in)
+		if got.Model != tt.want || got.Fits != tt.fits {
+			t.Errorf("%s: ChooseModel = %s (fits %v); want %s (fits %v)", tt.name, got.Model, got.Fits, tt.want, tt.fits)
+		}
+	}
+}
+
+func TestChooseModel_Estimate(t *testing.T) {
+	in := AutoInput{CPUs: 8, Audio: 2 * time.Hour}
+	c := ChooseModel(in)
+	if want := time.Duration(c.RTF * float64(2*time.Hour)); c.Estimate != want || c.Estimate <= 0 {
+		t.Errorf("Estimate = %v; want %v", c.Estimate, want)
+	}
+	if d := c.describe(in); !strings.HasPrefix(d, c.Model+" (2h00m00s of audio, 8 CPUs: about ") {
+		t.Errorf("describe = %q", d)
+	}
+}
+
+func TestSurveyFiles(t *testing.T) {
+	defer func(p func(string) ([]AudioTrack, error)) { probeTracks = p }(probeTracks)
+	probeTracks = func(path string) ([]AudioTrack, error) {
+		switch path {
+		case "eng.mkv":
+			return []AudioTrack{{StreamIndex: 1, Language: "eng", Duration: time.Hour}}, nil
+		case "dual.mkv":
+			return []AudioTrack{
+				{StreamIndex: 1, Language: "eng", Duration: 2 * time.Hour},
+				{StreamIndex: 2, Language: "spa", Duration: 2 * time.Hour},
+			}, nil
+		case "untagged.mp3":
+			return []AudioTrack{{StreamIndex: 0, Duration: 30 * time.Minute}}, nil
+		}
+		return nil, errors.New("unreadable")
+	}
+	files := func(paths ...string) []MediaFile {
+		var fs []MediaFile
+		for _, p := range paths {
+			fs = append(fs, MediaFile{Path: p})
+		}
+		return fs
+	}
+	cfg := Config{AudioTrack: -1, TrustTrackLanguage: true}
+
+	tests := []struct {
+		name    string
+		files   []MediaFile
+		cfg     Config
+		total   time.Duration
+		english bool
+	}{
+		{"English tags", files("eng.mkv", "eng.mkv"), cfg, 2 * time.Hour, true},
+		{"any non-English track", files("eng.mkv", "dual.mkv"), cfg, 3 * time.Hour, false},
+		{"English track picked", files("dual.mkv"), Config{AudioTrack: 1, TrustTrackLanguage: true}, 2 * time.Hour, true},
+		{"tags not trusted", files("eng.mkv"), Config{AudioTrack: -1}, time.Hour, false},
+		{"untagged", files("untagged.mp3"), cfg, 30 * time.Minute, false},
+		{"--language en", files("untagged.mp3", "broken.avi"), Config{Language: "en", AudioTrack: -1}, 30 * time.Minute, true},
+		{"code-switching", files("eng.mkv"), Config{AudioTrack: -1, CodeSwitching: true}, time.Hour, false},
+		{"list option", []MediaFile{{Path: "untagged.mp3", Options: &FileOptions{Language: "en", AudioTrack: -1}}}, cfg, 30 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		total, english := surveyFiles(tt.files, tt.cfg)
+		if total != tt.total || english != tt.english {
+			t.Errorf("%s: surveyFiles = %v, %v; want %v, %v", tt.name, total, english, tt.total, tt.english)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"strings"
+	"time"
+)
+
+// defaultCascadeThreshold is the segment confidence below which --cascade
+// transcribes a segment again. Whisper's own fallback triggers at an
+// average log probability of -1, a confidence of about 0.37; the cascade
+// is more eager because the second model is far more accurate.
+const defaultCascadeThreshold = 0.5
+
+// maxCompressionRatio is the zlib compression ratio of a segment's text
+// above which it is taken for a repetition loop, whisper's most common
+// hallucination. OpenAI's whisper uses the same limit.
+const maxCompressionRatio = 2.4
+
+// minCascadeRegion is the least audio transcribed again around a suspect
+// segment, so the second model gets some context. Regions only grow into
+// the silence between segments, never over their neighbours.
+const minCascadeRegion = 2 * time.Second
+
+// cascadeBatch is the most audio transcribed again in one call. Whisper
+// pads every call to a 30-second window, so nearby regions cost no more
+// together than one does alone.
+const cascadeBatch = 30 * time.Second
+
+// cascadeRegion is a run of consecutive first-pass segments to transcribe
+// again, and the audio to transcribe for them.
+type cascadeRegion struct {
+	first, last int // segment indices, inclusive
+	start, end  time.Duration
+}
+
+// suspectSegment reports whether a first-pass segment should be
+// transcribed again: whisper was unsure of its tokens, or its text repeats
+// itself the way hallucinations do.
+func suspectSegment(seg Segment, threshold float64) bool {
+	if strings.TrimSpace(seg.Text) == "" {
+		return false
+	}
+	return (seg.Confidence > 0 && seg.Confidence < threshold) || repetitive(seg.Text)
+}
+
+// repetitive reports whether text compresses too well to be speech.
+func repetitive(text string) bool {
+	return compressionRatio(text) > maxCompressionRatio
+}
+
+// compressionRatio returns how many times smaller text gets with zlib.
+func compressionRatio(text string) float64 {
+	if text == "" {
+		return 0
+	}
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte(text))
+	zw.Close()
+	return float64(len(text)) / float64(b.Len())
+}
+
+// cascadeRegions groups the segments marked in suspect into regions of
+// consecutive ones less than minCascadeRegion apart, each widened to
+// minCascadeRegion where the silence around it allows. length is the
+// duration of the audio.
+func cascadeRegions(segs []Segment, suspect []bool, length time.Duration) []cascadeRegion {
+	var regions []cascadeRegion
+	for i, seg := range segs {
+		if !suspect[i] {
+			continue
+		}
+		if n := len(regions); n > 0 && regions[n-1].last == i-1 && seg.Start-regions[n-1].end < minCascadeRegion {
+			regions[n-1].last = i
+			regions[n-1].end = seg.End
+			continue
+		}
+		regions = append(regions, cascadeRegion{first: i, last: i, start: seg.Start, end: seg.End})
+	}
+
+	for i := range regions {
+		r := &regions[i]
+		lo, hi := time.Duration(0), length
+		if r.first > 0 {
+			lo = min(segs[r.first-1].End, r.start)
+		}
+		if r.last+1 < len(segs) {
+			hi = max(segs[r.last+1].Start, r.end)
+		}
+		if grow := minCascadeRegion - (r.end - r.start); grow > 0 {
+			r.start = max(lo, r.start-grow/2)
+			r.end = min(hi, r.start+minCascadeRegion)
+			r.start = max(lo, r.end-minCascadeRegion)
+		}
+	}
+	return regions
+}
+
+// batchRegions groups consecutive regions into batches that span at most
+// cascadeBatch, to be transcribed in one call. Regions whose segments carry
+// different languages, as in code-switching output, are not batched
+// together. A region longer than cascadeBatch is a batch of its own.
+func batchRegions(segs []Segment, regions []cascadeRegion) [][]cascadeRegion {
+	var batches [][]cascadeRegion
+	for _, r := range regions {
+		if n := len(batches); n > 0 {
+			b := batches[n-1]
+			if r.end-b[0].start <= cascadeBatch && segs[r.first].Language == segs[b[0].first].Language {
+				batches[n-1] = append(b, r)
+				continue
+			}
+		}
+		batches = append(batches, []cascadeRegion{r})
+	}
+	return batches
+}
+
+// splitBatch assigns the segments of a batch transcription to the regions
+// their midpoints fall in, clamped to them. Segments between the regions
+// are dropped; the first pass is kept there.
+func splitBatch(redone []Segment, batch []cascadeRegion) [][]Segment {
+	parts := make([][]Segment, len(batch))
+	for _, s := range redone {
+		mid := (s.Start + s.End) / 2
+		for i, r := range batch {
+			if mid >= r.start && mid <= r.end {
+				s.Start = min(max(s.Start, r.start), r.end)
+				s.End = min(max(s.End, r.start), r.end)
+				parts[i] = append(parts[i], s)
+				break
+			}
+		}
+	}
+	return parts
+}
+
+// allRepetitive reports whether every segment is a repetition loop.
+func allRepetitive(segs []Segment) bool {
+	for _, s := range segs {
+		if !repetitive(s.Text) {
+			return false
+		}
+	}
+	return true
+}
+
+// regionScore rates segments for choosing between two transcriptions of
+// the same audio: their mean confidence, with repetition loops counting
+// as zero.
+func regionScore(segs []Segment) float64 {
+	if len(segs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range segs {
+		if !repetitive(s.Text) {
+			sum += s.Confidence
+		}
+	}
+	return sum / float64(len(segs))
+}
+
+// RefineSegments is the second pass of --cascade: it transcribes the
+// suspect regions of segs again with engine, a larger model, and splices
+// in the results that score better than the first pass. Nearby regions
+// are transcribed together, in one call of up to cascadeBatch. A region the
+// second model finds no speech in is dropped if the first pass only
+// produced repetition loops there. samples is the whole track, which segs
+// are timed against; language is the track's language, unless segments
+// carry their own.
+//
+// It returns the segments, the number of regions tried and the number
+// replaced. A region that fails to transcribe keeps its first-pass
+// segments; only cancelling ctx is an error.
+func RefineSegments(ctx context.Context, engine Transcriber, samples []float32, segs []Segment, language string, threshold float64) (refined []Segment, tried, replaced int, err error) {
+	suspect := make([]bool, len(segs))
+	for i, seg := range segs {
+		suspect[i] = suspectSegment(seg, threshold)
+	}
+	regions := cascadeRegions(segs, suspect, samplesToDuration(len(samples)))
+	next := 0
+	for _, batch := range batchRegions(segs, regions) {
+		span := cascadeRegion{first: batch[0].first, last: batch[len(batch)-1].last, start: batch[0].start, end: batch[len(batch)-1].end}
+		redone, err := transcribeRegion(ctx, engine, samples, span, segs[span.first].Language, TranscribeOptions{Language: language})
+		if ctx.Err() != nil {
+			return nil, tried, replaced, ctx.Err()
+		}
+		parts := splitBatch(redone, batch)
+		for i, r := range batch {
+			refined = append(refined, segs[next:r.first]...)
+			next = r.last + 1
+			old := segs[r.first:next]
+			tried++
+			if err != nil {
+				refined = append(refined, old...)
+				continue
+			}
+			if redone := parts[i]; (len(redone) == 0 && allRepetitive(old)) || (len(redone) > 0 && regionScore(redone) > regionScore(old)) {
+				refined = append(refined, redone...)
+				replaced++
+			} else {
+				refined = append(refined, old...)
+			}
+		}
+	}
+	refined = append(refined, segs[next:]...)
+	return refined, tried, replaced, nil
+}
+
+// transcribeRegion transcribes the audio of region r again with opts and
+// returns the segments timed against the whole track and clamped to the
+// region. If lang is set, it overrides opts.Language and is recorded in
+// the segments, as for code-switching output.
+func transcribeRegion(ctx context.Context, engine Transcriber, samples []float32, r cascadeRegion, lang string, opts TranscribeOptions) ([]Segment, error) {
+	if lang != "" {
+		opts.Language = lang
+	}
+	from := min(int(r.start*16000/time.Second), len(samples))
+	to := min(int(r.end*16000/time.Second), len(samples))
+	if to <= from {
+		return nil, nil
+	}
+	segs, err := engine.Transcribe(ctx, samples[from:to], opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range segs {
+		segs[i].Start = min(max(segs[i].Start+r.start, r.start), r.end)
+		segs[i].End = min(max(segs[i].End+r.start, r.start), r.end)
+		if lang != "" {
+			segs[i].Language = lang
+		}
+	}
+	return segs, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepetitive(t *testing.T) {
+	if repetitive("The committee will reconvene after lunch to discuss the budget.") {
+		t.Error("ordinary sentence taken for a repetition loop")
+	}
+	if !repetitive(strings.Repeat("Thank you. ", 20)) {
+		t.Error("repetition loop not detected")
+	}
+}
+
+func TestCascadeRegions(t *testing.T) {
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	segs := []Segment{
+		{Start: sec(0), End: sec(3), Text: "fine", Confidence: 0.9},
+		{Start: sec(3.2), End: sec(3.8), Text: "mumble", Confidence: 0.2},
+		{Start: sec(10), End: sec(12), Text: "unsure", Confidence: 0.3},
+		{Start: sec(12), End: sec(14), Text: strings.Repeat("Thank you. ", 20), Confidence: 0.95},
+		{Start: sec(14.5), End: sec(16), Text: "fine", Confidence: 0.8},
+		{Start: sec(16), End: sec(17), Text: " ", Confidence: 0.1},
+	}
+	suspect := make([]bool, len(segs))
+	for i, seg := range segs {
+		suspect[i] = suspectSegment(seg, 0.5)
+	}
+	got := cascadeRegions(segs, suspect, sec(20))
+	want := []cascadeRegion{
+		// Widened to 2s, but not into the first segment.
+		{first: 1, last: 1, start: sec(3), end: sec(5)},
+		// Consecutive suspects are merged; already long enough.
+		{first: 2, last: 3, start: sec(10), end: sec(14)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cascadeRegions = %+v; want %+v", got, want)
+	}
+}
+
+// cascadeCalls records the audio length and language of each request to
+// a fakeTranscriber from cascadeTranscriber.
+type cascadeCalls struct {
+	lengths []time.Duration
+	langs   []string
+}
+
+// cascadeTranscriber returns a fakeTranscriber that answers each request
+// with the next of results and records it in calls.
+func cascadeTranscriber(calls *cascadeCalls, results ...[]Segment) fakeTranscriber {
+	return fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		calls.lengths = append(calls.lengths, samplesToDuration(len(samples)))
+		calls.langs = append(calls.langs, opts.Language)
+		res := results[0]
+		results = results[1:]
+		return res, nil
+	}}
+}
+
+func TestRefineSegments(t *testing.T) {
+	segs := []Segment{
+		{Start: 0, End: 2 * time.Second, Text: "good", Confidence: 0.9},
+		{Start: 2 * time.Second, End: 4 * time.Second, Text: "gud", Confidence: 0.3},
+		{Start: 4 * time.Second, End: 6 * time.Second, Text: "fine", Confidence: 0.8},
+		{Start: 6 * time.Second, End: 8 * time.Second, Text: "hmm", Confidence: 0.4},
+		{Start: 8 * time.Second, End: 10 * time.Second, Text: "ok", Confidence: 0.8},
+		{Start: 10 * time.Second, End: 12 * time.Second, Text: strings.Repeat("Thank you. ", 20), Confidence: 0.9},
+	}
+	// The three regions (2-4s, 6-8s and 10-12s) are transcribed in one
+	// call, timed from 2s. What is heard between them is ignored.
+	var calls cascadeCalls
+	engine := cascadeTranscriber(&calls, []Segment{
+		{Start: 0, End: 2 * time.Second, Text: "good again", Confidence: 0.85},
+		{Start: 2 * time.Second, End: 4 * time.Second, Text: "fine again", Confidence: 0.99},
+		{Start: 4 * time.Second, End: 7 * time.Second, Text: "worse", Confidence: 0.2},
+	})
+	samples := make([]float32, 12*16000)
+
+	got, tried, replaced, err := RefineSegments(context.Background(), engine, samples, segs, "en", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tried != 3 || replaced != 2 {
+		t.Errorf("tried %d, replaced %d; want 3, 2", tried, replaced)
+	}
+	var texts []string
+	for _, s := range got {
+		texts = append(texts, s.Text)
+	}
+	// The better transcription is spliced in, the worse one is not, and the
+	// loop the second model hears no speech in is dropped.
+	if want := "good|good again|fine|hmm|ok"; strings.Join(texts, "|") != want {
+		t.Errorf("refined texts %q; want %q", strings.Join(texts, "|"), want)
+	}
+	if got[1].Start != 2*time.Second || got[1].End != 4*time.Second {
+		t.Errorf("spliced segment at %v-%v; want 2s-4s", got[1].Start, got[1].End)
+	}
+	if want := []time.Duration{10 * time.Second}; !reflect.DeepEqual(calls.lengths, want) {
+		t.Errorf("transcribed %v of audio per call; want %v", calls.lengths, want)
+	}
+	if calls.langs[0] != "en" {
+		t.Errorf("region transcribed as %q; want en", calls.langs[0])
+	}
+}
+
+func TestBatchRegions(t *testing.T) {
+	sec := func(s int) time.Duration { return time.Duration(s) * time.Second }
+	segs := []Segment{{}, {}, {}, {Language: "de"}, {Language: "de"}}
+	regions := []cascadeRegion{
+		{first: 0, last: 0, start: sec(0), end: sec(2)},
+		{first: 1, last: 1, start: sec(20), end: sec(22)},
+		{first: 2, last: 2, start: sec(40), end: sec(42)}, // too far from the first
+		{first: 3, last: 3, start: sec(44), end: sec(46)}, // another language
+		{first: 4, last: 4, start: sec(50), end: sec(52)},
+	}
+	var sizes []int
+	for _, b := range batchRegions(segs, regions) {
+		sizes = append(sizes, len(b))
+	}
+	if want := []int{2, 1, 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batch sizes = %v; want %v", sizes, want)
+	}
+}
+
+func TestRefineSegments_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	segs := []Segment{{Start: 0, End: time.Second, Text: "x", Confidence: 0.1}}
+	engine := cascadeTranscriber(&cascadeCalls{}, nil)
+	if _, _, _, err := RefineSegments(ctx, engine, make([]float32, 16000), segs, "", 0.5); err == nil {
+		t.Error("RefineSegments succeeded after cancellation")
+	}
+}
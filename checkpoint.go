@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkpointInterval is how often progress of a running transcription is
+// saved. Files transcribed faster than this never leave a checkpoint.
+const checkpointInterval = 30 * time.Second
+
+// checkpointDirOverride allows tests to redirect checkpoint files.
+var checkpointDirOverride string
+
+// CheckpointDir returns the directory holding checkpoints of interrupted
+// transcriptions, next to the model cache.
+func CheckpointDir() string {
+	if checkpointDirOverride != "" {
+		return checkpointDirOverride
+	}
+	return filepath.Join(filepath.Dir(CacheDir()), "checkpoints")
+}
+
+// CheckpointKey identifies a transcription. A checkpoint is only resumed
+// if every field matches, i.e. the same source file (unchanged), audio
+// track, model and language.
+type CheckpointKey struct {
+	Source   string    `json:"source"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Stream   int       `json:"stream"`
+	Model    string    `json:"model"`
+	Language string    `json:"language"`
+}
+
+// Checkpoint is the saved progress of a transcription: the segments
+// decoded so far and how much of the audio they cover.
+type Checkpoint struct {
+	Key      CheckpointKey `json:"key"`
+	Offset   time.Duration `json:"offset"`
+	Segments []Segment     `json:"segments"`
+}
+
+// checkpointPath returns the file for key's source and audio track, so a
+// rerun with other settings replaces rather than accumulates checkpoints.
+func checkpointPath(key CheckpointKey) string {
+	sum := sha256.Sum256([]byte(key.Source + "\x00" + strconv.Itoa(key.Stream)))
+	return filepath.Join(CheckpointDir(), hex.EncodeToString(sum[:8])+".json")
+}
+
+// checkpointer records segments while a track is transcribed, saving them
+// every checkpointInterval so an interrupted run can continue later.
+type checkpointer struct {
+	cp       Checkpoint
+	base     time.Duration // start of the audio being transcribed in this run
+	lastSave time.Time
+	now      func() time.Time
+}
+
+// newCheckpointer prepares checkpoints for a track of the media file at
+// path, length long. If a matching checkpoint exists, it is loaded and
+// Offset reports where to resume. It returns nil if the source cannot be
+// identified.
+func newCheckpointer(path string, stream int, model, language string, length time.Duration) *checkpointer {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil
+	}
+	key := CheckpointKey{
+		Source:   abs,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UTC(),
+		Stream:   stream,
+		Model:    model,
+		Language: language,
+	}
+
+	c := &checkpointer{cp: Checkpoint{Key: key}, now: time.Now}
+	c.lastSave = c.now()
+	if data, err := os.ReadFile(checkpointPath(key)); err == nil {
+		var saved Checkpoint
+		if json.Unmarshal(data, &saved) == nil && saved.Key == key && saved.Offset < length {
+			c.cp = saved
+			c.base = saved.Offset
+		}
+	}
+	return c
+}
+
+// Offset returns how much audio the loaded checkpoint covers, or 0.
+func (c *checkpointer) Offset() time.Duration {
+	return c.base
+}
+
+// Segments returns all segments recorded so far, including those loaded
+// from the checkpoint.
+func (c *checkpointer) Segments() []Segment {
+	return c.cp.Segments
+}
+
+// Add records a segment decoded in this run, with timestamps relative to
+// Offset, and saves the checkpoint if it is due.
+func (c *checkpointer) Add(seg Segment) {
+	seg.Start += c.base
+	seg.End += c.base
+	c.cp.Segments = append(c.cp.Segments, seg)
+	c.cp.Offset = seg.End
+	if c.now().Sub(c.lastSave) >= checkpointInterval {
+		c.Save()
+	}
+}
+
+// Save writes the checkpoint if there is progress to keep. The file is
+// replaced atomically, so a crash mid-write leaves the previous one.
+func (c *checkpointer) Save() error {
+	c.lastSave = c.now()
+	if len(c.cp.Segments) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(c.cp)
+	if err != nil {
+		return err
+	}
+	path := checkpointPath(c.cp.Key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Remove deletes the checkpoint once the track is done.
+func (c *checkpointer) Remove() {
+	os.Remove(checkpointPath(c.cp.Key))
+}
+
+// pruneCheckpoints deletes checkpoints that can no longer be resumed
+// because their source file is gone or has changed, and returns how many.
+func pruneCheckpoints() (int, error) {
+	dir := CheckpointDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			continue
+		}
+		var cp Checkpoint
+		data, err := os.ReadFile(path)
+		if err == nil && json.Unmarshal(data, &cp) == nil && !strings.HasPrefix(e.Name(), ".") {
+			info, err := os.Stat(cp.Key.Source)
+			if err == nil && info.Size() == cp.Key.Size && info.ModTime().UTC().Equal(cp.Key.ModTime) {
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointer_SaveAndResume(t *testing.T) {
+	defer func(d string) { checkpointDirOverride = d }(checkpointDirOverride)
+	checkpointDirOverride = t.TempDir()
+	src := filepath.Join(t.TempDir(), "movie.mkv")
+	os.WriteFile(src, []byte("video"), 0644)
+
+	c := newCheckpointer(src, 1, "base", "en", time.Hour)
+	if c.Offset() != 0 {
+		t.Fatalf("fresh checkpointer Offset = %v; want 0", c.Offset())
+	}
+	c.Add(Segment{Start: 0, End: 2 * time.Second, Text: "one"})
+	c.Add(Segment{Start: 3 * time.Second, End: 5 * time.Second, Text: "two"})
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	r := newCheckpointer(src, 1, "base", "en", time.Hour)
+	if r.Offset() != 5*time.Second {
+		t.Fatalf("resumed Offset = %v; want 5s", r.Offset())
+	}
+	if !reflect.DeepEqual(r.Segments(), c.Segments()) {
+		t.Errorf("resumed segments = %v; want %v", r.Segments(), c.Segments())
+	}
+
+	// Segments of the resumed run are relative to the offset.
+	r.Add(Segment{Start: time.Second, End: 2 * time.Second, Text: "three"})
+	if got := r.Segments()[2]; got.Start != 6*time.Second || got.End != 7*time.Second {
+		t.Errorf("resumed segment at %v-%v; want 6s-7s", got.Start, got.End)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		stream   int
+		model    string
+		language string
+		length   time.Duration
+	}{
+		{"other track", 2, "base", "en", time.Hour},
+		{"other model", 1, "small", "en", time.Hour},
+		{"other language", 1, "base", "de", time.Hour},
+		{"shorter audio", 1, "base", "en", 5 * time.Second},
+	} {
+		if got := newCheckpointer(src, tc.stream, tc.model, tc.language, tc.length).Offset(); got != 0 {
+			t.Errorf("%s: Offset = %v; want 0", tc.name, got)
+		}
+	}
+
+	// A changed source file invalidates the checkpoint.
+	os.WriteFile(src, []byte("other video"), 0644)
+	if got := newCheckpointer(src, 1, "base", "en", time.Hour).Offset(); got != 0 {
+		t.Errorf("after source changed: Offset = %v; want 0", got)
+	}
+}
+
+func TestCheckpointer_SavesPeriodically(t *testing.T) {
+	defer func(d string) { checkpointDirOverride = d }(checkpointDirOverride)
+	checkpointDirOverride = t.TempDir()
+	src := filepath.Join(t.TempDir(), "movie.mkv")
+	os.WriteFile(src, nil, 0644)
+
+	now := time.Unix(1000, 0)
+	c := newCheckpointer(src, 0, "base", "en", time.Hour)
+	c.now = func() time.Time { return now }
+	c.lastSave = now
+
+	c.Add(Segment{End: time.Second, Text: "early"})
+	if _, err := os.Stat(checkpointPath(c.cp.Key)); err == nil {
+		t.Fatal("checkpoint saved before the interval passed")
+	}
+	now = now.Add(checkpointInterval)
+	c.Add(Segment{Start: time.Second, End: 2 * time.Second, Text: "later"})
+	if got := newCheckpointer(src, 0, "base", "en", time.Hour).Offset(); got != 2*time.Second {
+		t.Errorf("Offset after periodic save = %v; want 2s", got)
+	}
+
+	c.Remove()
+	if _, err := os.Stat(checkpointPath(c.cp.Key)); err == nil {
+		t.Error("checkpoint still exists after Remove")
+	}
+}
+
+func TestProcessFiles_ResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "long.mkv")
+	os.WriteFile(path, nil, 0644)
+
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"})
+	extractAudio = func(context.Context, string, int) ([]float32, error) {
+		return make([]float32, 10*16000), nil
+	}
+
+	cfg := Config{Format: "srt", Model: "base", Overwrite: OverwriteAlways, AudioTrack: -1, Verbose: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	NewProcessor(cfg, []Transcriber{interruptedTranscriber(cancel)}, &PartialFiles{}).ProcessFiles(ctx, []MediaFile{{Path: path}})
+	out := filepath.Join(dir, "long.srt")
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("a subtitle file was written for the interrupted run")
+	}
+
+	var given int
+	remainder := fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		given = len(samples)
+		return []Segment{{Start: time.Second, End: 3 * time.Second, Text: "third"}}, nil
+	}}
+	NewProcessor(cfg, []Transcriber{remainder}, &PartialFiles{}).ProcessFiles(context.Background(), []MediaFile{{Path: path}})
+	if given != 6*16000 {
+		t.Errorf("resumed run transcribed %d samples; want %d", given, 6*16000)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	srt := string(data)
+	for _, want := range []string{"first", "second", "00:00:05,000 --> 00:00:07,000\nthird"} {
+		if !strings.Contains(srt, want) {
+			t.Errorf("output missing %q:\n%s", want, srt)
+		}
+	}
+	if entries, _ := os.ReadDir(checkpointDirOverride); len(entries) != 0 {
+		t.Errorf("%d checkpoint files left after the track finished", len(entries))
+	}
+}
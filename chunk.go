@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// minChunkLength is the shortest chunk (in 16 kHz samples) a file is
+	// split into; shorter chunks lose more context than they gain in speed.
+	minChunkLength = 2 * 60 * 16000
+
+	// chunkSearch is how far (in samples) from the ideal split point the
+	// quietest moment is searched for.
+	chunkSearch = 20 * 16000
+
+	// chunkFrame is the frame length (in samples) over which loudness is
+	// measured when looking for silence.
+	chunkFrame = 1600
+
+	// chunkOverlap is the audio (in samples) each chunk also decodes
+	// before its own start, so a word cut at the boundary is still heard
+	// whole by one of the two chunks.
+	chunkOverlap = 16000
+)
+
+// audioChunk is a span of audio transcribed on its own. Start and End are
+// the samples the chunk is responsible for; decoding starts at From, which
+// is before Start by the overlap (except for the first chunk).
+type audioChunk struct {
+	From  int
+	Start int
+	End   int
+}
+
+// splitAtSilence divides total samples into chunks of roughly length
+// samples each, moving every boundary to the quietest frame within
+// chunkSearch of its ideal position.
+func splitAtSilence(samples []float32, length int) []audioChunk {
+	total := len(samples)
+	var chunks []audioChunk
+	start := 0
+	for total-start > length+length/2 {
+		ideal := start + length
+		cut := quietestFrame(samples, ideal-chunkSearch, ideal+chunkSearch)
+		if cut <= start {
+			cut = ideal
+		}
+		chunks = append(chunks, audioChunk{Start: start, End: cut})
+		start = cut
+	}
+	chunks = append(chunks, audioChunk{Start: start, End: total})
+
+	for i := range chunks {
+		chunks[i].From = chunks[i].Start
+		if i > 0 {
+			chunks[i].From = max(0, chunks[i].Start-chunkOverlap)
+		}
+	}
+	return chunks
+}
+
+// quietestFrame returns the middle of the chunkFrame-long frame with the
+// lowest energy in samples[from:to].
+func quietestFrame(samples []float32, from, to int) int {
+	from = max(0, from)
+	to = min(len(samples), to)
+	best, bestEnergy := (from+to)/2, math.Inf(1)
+	for f := from; f+chunkFrame <= to; f += chunkFrame {
+		var e float64
+		for _, s := range samples[f : f+chunkFrame] {
+			e += float64(s) * float64(s)
+		}
+		if e < bestEnergy {
+			best, bestEnergy = f+chunkFrame/2, e
+		}
+	}
+	return best
+}
+
+// chunkLength picks the chunk length for a file of total samples spread
+// over workers: two chunks per worker so faster workers can pick up the
+// slack, but no shorter than minChunkLength.
+func chunkLength(total, workers int) int {
+	return max(minChunkLength, total/(2*workers)+1)
+}
+
+// TranscribeChunked transcribes one long recording by splitting it at
+// quiet moments and transcribing the chunks concurrently, one per engine
+// at a time. The engines must be independent (separate WhisperStates).
+// Segments are stitched back in order with timestamps relative to the
+// whole recording, and duplicates from the chunk overlaps removed. The
+// first chunk that fails stops the others and is returned as the error.
+//
+// onProgress, if non-nil, is called with the overall percentage [0..100].
+func TranscribeChunked(ctx context.Context, engines []Transcriber, samples []float32, language string, onProgress func(int)) ([]Segment, error) {
+	chunks := splitAtSilence(samples, chunkLength(len(samples), len(engines)))
+	results := make([][]Segment, len(chunks))
+
+	// A failed chunk cancels the rest; its error is the one returned.
+	chunkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failOnce sync.Once
+	var failed error
+
+	// Overall progress is the sample-weighted sum of the chunks' progress.
+	var progressMu sync.Mutex
+	chunkPct := make([]int, len(chunks))
+	decoded := 0
+	for _, c := range chunks {
+		decoded += c.End - c.From
+	}
+	report := func(i, pct int) {
+		if onProgress == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		chunkPct[i] = pct
+		done := 0
+		for j, c := range chunks {
+			done += (c.End - c.From) * chunkPct[j] / 100
+		}
+		onProgress(done * 100 / decoded)
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for _, engine := range engines {
+		wg.Add(1)
+		go func(engine Transcriber) {
+			defer wg.Done()
+			for i := range queue {
+				if chunkCtx.Err() != nil {
+					continue
+				}
+				c := chunks[i]
+				segs, err := engine.Transcribe(chunkCtx, samples[c.From:c.End], TranscribeOptions{
+					Language:   language,
+					OnProgress: func(pct int) { report(i, pct) },
+				})
+				offset := samplesToDuration(c.From)
+				for j := range segs {
+					segs[j].Start += offset
+					segs[j].End += offset
+				}
+				if err != nil && chunkCtx.Err() == nil {
+					failOnce.Do(func() {
+						failed = err
+						cancel()
+					})
+				}
+				results[i] = segs
+			}
+		}(engine)
+	}
+dispatch:
+	for i := range chunks {
+		select {
+		case queue <- i:
+		case <-chunkCtx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return stitchChunks(chunks, results), nil
+}
+
+// stitchChunks joins the segments of each chunk (already offset to the
+// whole recording). A segment belongs to the chunk whose own span contains
+// its midpoint, which drops what a chunk transcribed of its overlap; a
+// segment repeating the text of the one before it across a boundary is
+// dropped as well.
+func stitchChunks(chunks []audioChunk, results [][]Segment) []Segment {
+	var out []Segment
+	for i, c := range chunks {
+		start, end := samplesToDuration(c.Start), samplesToDuration(c.End)
+		for _, seg := range results[i] {
+			mid := seg.Start + (seg.End-seg.Start)/2
+			if mid < start || (mid >= end && i < len(chunks)-1) {
+				continue
+			}
+			if n := len(out); n > 0 && seg.Start < out[n-1].End+time.Second &&
+				normalizeText(seg.Text) == normalizeText(out[n-1].Text) {
+				continue
+			}
+			out = append(out, seg)
+		}
+	}
+	return out
+}
+
+// normalizeText lower-cases s and strips punctuation and extra spaces, for
+// comparing transcriptions of the same speech.
+func normalizeText(s string) string {
+	f := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(f, " ")
+}
+
+// samplesToDuration converts a 16 kHz sample count to a duration.
+func samplesToDuration(n int) time.Duration {
+	return time.Duration(n) * time.Second / 16000
+}
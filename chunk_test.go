@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSplitAtSilence_CutsAtQuietestFrame(t *testing.T) {
+	// 10 minutes of loud audio with a silent second at 4:50.
+	samples := make([]float32, 10*60*16000)
+	for i := range samples {
+		samples[i] = 0.5
+	}
+	quiet := (4*60 + 50) * 16000
+	for i := quiet; i < quiet+16000; i++ {
+		samples[i] = 0
+	}
+
+	chunks := splitAtSilence(samples, 5*60*16000)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks; want 2: %+v", len(chunks), chunks)
+	}
+	if cut := chunks[0].End; cut < quiet || cut >= quiet+16000 {
+		t.Errorf("cut at %v; want inside the silence at 4m50s", samplesToDuration(cut))
+	}
+	if chunks[1].Start != chunks[0].End || chunks[1].End != len(samples) {
+		t.Errorf("chunks do not tile the audio: %+v", chunks)
+	}
+	if chunks[0].From != 0 || chunks[1].From != chunks[1].Start-chunkOverlap {
+		t.Errorf("unexpected overlap: %+v", chunks)
+	}
+}
+
+func TestSplitAtSilence_ShortAudioSingleChunk(t *testing.T) {
+	samples := make([]float32, 3*60*16000)
+	chunks := splitAtSilence(samples, 2*60*16000)
+	want := []audioChunk{{From: 0, Start: 0, End: len(samples)}}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("splitAtSilence = %+v; want %+v", chunks, want)
+	}
+}
+
+func TestStitchChunks_DropsOverlapAndDuplicates(t *testing.T) {
+	s := func(sec float64) time.Duration { return time.Duration(sec * float64(time.Second)) }
+	chunks := []audioChunk{
+		{From: 0, Start: 0, End: 10 * 16000},
+		{From: 9 * 16000, Start: 10 * 16000, End: 20 * 16000},
+	}
+	results := [][]Segment{
+		{{Start: s(0), End: s(4), Text: "one"}, {Start: s(5), End: s(9.8), Text: "Two words."}},
+		{
+			{Start: s(9), End: s(9.9), Text: "words"},        // inside the overlap
+			{Start: s(9.6), End: s(10.6), Text: "two words"}, // repeated across the boundary
+			{Start: s(11), End: s(15), Text: "three"},
+		},
+	}
+
+	got := stitchChunks(chunks, results)
+	var texts []string
+	for _, seg := range got {
+		texts = append(texts, seg.Text)
+	}
+	if want := []string{"one", "Two words.", "three"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("stitched texts = %q; want %q", texts, want)
+	}
+}
+
+func TestTranscribeChunked(t *testing.T) {
+	// Every call is transcribed to one segment spanning its input, so the
+	// stitched result shows which chunks were transcribed. Texts differ so
+	// none are dropped as duplicates.
+	var mu sync.Mutex
+	calls := make([]int, 2)
+	echo := func(engine int) fakeTranscriber {
+		return fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+			mu.Lock()
+			calls[engine]++
+			text := fmt.Sprintf("chunk %d %d", engine, calls[engine])
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond) // let the other engine take the next chunk
+			if opts.OnProgress != nil {
+				opts.OnProgress(100)
+			}
+			return []Segment{{Start: 0, End: samplesToDuration(len(samples)), Text: text}}, nil
+		}}
+	}
+	samples := make([]float32, 20*60*16000)
+	last := 0
+	segments, err := TranscribeChunked(context.Background(), []Transcriber{echo(0), echo(1)}, samples, "en", func(pct int) { last = pct })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20 minutes over 2 engines: 4 chunks of about 5 minutes.
+	if len(segments) != 4 || calls[0]+calls[1] != 4 {
+		t.Fatalf("got %d segments from %d calls; want 4", len(segments), calls[0]+calls[1])
+	}
+	if calls[0] == 0 || calls[1] == 0 {
+		t.Errorf("chunks not spread over engines: %d/%d", calls[0], calls[1])
+	}
+	for i := 1; i < len(segments); i++ {
+		if segments[i].Start < segments[i-1].Start {
+			t.Errorf("segments out of order: %+v", segments)
+		}
+	}
+	if end := segments[len(segments)-1].End; end != 20*time.Minute {
+		t.Errorf("last segment ends at %v; want 20m0s", end)
+	}
+	if last != 100 {
+		t.Errorf("final progress = %d; want 100", last)
+	}
+}
+
+func TestTranscribeChunked_StopsOnFirstError(t *testing.T) {
+	// The first chunk fails; later ones wait for cancellation.
+	var calls atomic.Int32
+	engine := fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("decoder failed")
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	samples := make([]float32, 60*60*16000)
+	_, err := TranscribeChunked(context.Background(), []Transcriber{engine, engine}, samples, "en", nil)
+	if err == nil || err.Error() != "decoder failed" {
+		t.Errorf("TranscribeChunked error = %v; want the failed chunk's", err)
+	}
+	// 4 chunks: the first fails, the one running alongside is cancelled,
+	// and no more are started.
+	if n := calls.Load(); n > 2 {
+		t.Errorf("%d chunks transcribed after the first failed; want no more", n-1)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// codeSwitchWindow is the length (in 16 kHz samples) of each audio window
+// probed for its language in code-switching mode. Shorter windows follow
+// language changes more closely but give whisper less context per probe.
+const codeSwitchWindow = 10 * 16000
+
+// languageRegion is a contiguous span of audio in a single language.
+// Start and End are sample offsets into the full 16 kHz sample slice.
+type languageRegion struct {
+	Start    int
+	End      int
+	Language string
+}
+
+// mergeLanguageRegions turns per-window language guesses into contiguous
+// regions. langs[i] is the language detected for the window starting at
+// i*window; total is the full sample count. Windows with an empty guess
+// inherit the language of the preceding window, and adjacent windows in the
+// same language are merged into one region.
+func mergeLanguageRegions(langs []string, window, total int) []languageRegion {
+	var regions []languageRegion
+	for i, lang := range langs {
+		start := i * window
+		if start >= total {
+			break
+		}
+		end := start + window
+		if end > total {
+			end = total
+		}
+
+		if n := len(regions); n > 0 && (lang == "" || lang == regions[n-1].Language) {
+			regions[n-1].End = end
+			continue
+		}
+		regions = append(regions, languageRegion{Start: start, End: end, Language: lang})
+	}
+	return regions
+}
+
+// TranscribeCodeSwitching transcribes audio that may switch between
+// languages. The samples are split into fixed windows, the language of each
+// window is detected independently, and each resulting same-language region
+// is transcribed with that language forced. Every returned segment carries
+// the Language it was transcribed with. t is a WhisperModel or one of its
+// states.
+//
+// onProgress, if non-nil, is called with the overall percentage [0..100]
+// across all regions.
+func TranscribeCodeSwitching(ctx context.Context, t Transcriber, samples []float32, onProgress func(int)) ([]Segment, error) {
+	var langs []string
+	for start := 0; start < len(samples); start += codeSwitchWindow {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := start + codeSwitchWindow
+		if end > len(samples) {
+			end = len(samples)
+		}
+		langs = append(langs, t.DetectLanguage(ctx, samples[start:end]))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	done := 0
+	for _, r := range mergeLanguageRegions(langs, codeSwitchWindow, len(samples)) {
+		var regionProgress func(int)
+		if onProgress != nil {
+			base, size := done, r.End-r.Start
+			regionProgress = func(pct int) {
+				onProgress((base*100 + size*pct) / len(samples))
+			}
+		}
+
+		regionSegs, err := t.Transcribe(ctx, samples[r.Start:r.End], TranscribeOptions{Language: r.Language, OnProgress: regionProgress})
+		if err != nil {
+			return nil, err
+		}
+
+		offset := time.Duration(r.Start) * time.Second / 16000
+		for _, seg := range regionSegs {
+			seg.Start += offset
+			seg.End += offset
+			seg.Language = r.Language
+			segments = append(segments, seg)
+		}
+		done += r.End - r.Start
+	}
+	return segments, nil
+}
+
+// SegmentLanguages returns the distinct languages recorded on segments, in
+// order of first appearance.
+func SegmentLanguages(segments []Segment) []string {
+	seen := map[string]bool{}
+	var langs []string
+	for _, seg := range segments {
+		if seg.Language == "" || seen[seg.Language] {
+			continue
+		}
+		seen[seg.Language] = true
+		langs = append(langs, seg.Language)
+	}
+	return langs
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeLanguageRegions_MergesAdjacent(t *testing.T) {
+	got := mergeLanguageRegions([]string{"en", "en", "ja", "ja", "en"}, 10, 45)
+	want := []languageRegion{
+		{Start: 0, End: 20, Language: "en"},
+		{Start: 20, End: 40, Language: "ja"},
+		{Start: 40, End: 45, Language: "en"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeLanguageRegions = %+v; want %+v", got, want)
+	}
+}
+
+func TestMergeLanguageRegions_EmptyInheritsPrevious(t *testing.T) {
+	got := mergeLanguageRegions([]string{"ru", "", "en"}, 10, 30)
+	want := []languageRegion{
+		{Start: 0, End: 20, Language: "ru"},
+		{Start: 20, End: 30, Language: "en"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeLanguageRegions = %+v; want %+v", got, want)
+	}
+}
+
+func TestMergeLanguageRegions_LeadingEmpty(t *testing.T) {
+	got := mergeLanguageRegions([]string{"", "de"}, 10, 20)
+	want := []languageRegion{
+		{Start: 0, End: 10, Language: ""},
+		{Start: 10, End: 20, Language: "de"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeLanguageRegions = %+v; want %+v", got, want)
+	}
+}
+
+func TestSegmentLanguages(t *testing.T) {
+	segments := []Segment{
+		{Start: 0, End: time.Second, Language: "en"},
+		{Start: time.Second, End: 2 * time.Second, Language: "ja"},
+		{Start: 2 * time.Second, End: 3 * time.Second, Language: "en"},
+		{Start: 3 * time.Second, End: 4 * time.Second},
+	}
+	got := SegmentLanguages(segments)
+	want := []string{"en", "ja"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SegmentLanguages = %v; want %v", got, want)
+	}
+}
+
+func TestTranscribeCodeSwitching_StopsProbingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Language probes are counted and cancelled after the third.
+	probes := 0
+	d := fakeTranscriber{detect: func(ctx context.Context, samples []float32) string {
+		if probes++; probes == 3 {
+			cancel()
+		}
+		return "en"
+	}}
+	_, err := TranscribeCodeSwitching(ctx, d, make([]float32, 100*codeSwitchWindow), nil)
+	if err != context.Canceled || probes != 3 {
+		t.Errorf("err = %v after %d probes; want cancellation after 3", err, probes)
+	}
+}
@@ -1,66 +1,333 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 // MediaExts is the set of file extensions recognised as media (video + audio).
 var MediaExts = map[string]bool{
 	".mp4":  true,
+	".m4v":  true,
 	".mkv":  true,
 	".avi":  true,
 	".mov":  true,
 	".webm": true,
+	".ts":   true,
+	".m2ts": true,
+	".mts":  true,
+	".mpg":  true,
+	".mpeg": true,
+	".vob":  true,
+	".flv":  true,
+	".wmv":  true,
+	".3gp":  true,
 	".wav":  true,
 	".flac": true,
 	".mp3":  true,
+	".m4a":  true,
+	".m4b":  true,
+	".aac":  true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".wma":  true,
+	".mka":  true,
+	".ac3":  true,
+}
+
+// nonMediaExts lists common companion files that are never media, so
+// directory scans do not pay for sniffing them.
+var nonMediaExts = map[string]bool{
+	".srt": true, ".vtt": true, ".ass": true, ".ssa": true, ".sub": true, ".idx": true,
+	".txt": true, ".nfo": true, ".json": true, ".xml": true, ".md": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true,
+	".ttml": true, ".html": true, ".sha256": true,
+	".part": true, ".partial": true, ".tmp": true, ".lock": true, ".db": true, ".ini": true,
+}
+
+// sniffMedia inspects a file's content to decide whether it is media.
+// Tests replace it to avoid depending on real media files.
+var sniffMedia = IsMediaFile
+
+// isMediaPath reports whether path is a media file: known media extensions
+// are accepted outright, known companion files are rejected, and anything
+// else is sniffed.
+func isMediaPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if MediaExts[ext] {
+		return true
+	}
+	if nonMediaExts[ext] {
+		return false
+	}
+	return sniffMedia(path)
+}
+
+// DiscoverOptions controls how directories are searched for media files.
+type DiscoverOptions struct {
+	Recursive      bool     // descend into subdirectories
+	MaxDepth       int      // directory levels to search when Recursive; 0 = unlimited
+	Include        []string // if non-empty, files must match one of these globs
+	Exclude        []string // files and directories matching these globs are skipped
+	Hidden         bool     // include dot-files and dot-directories in recursive walks
+	FollowSymlinks bool     // follow symbolic links found inside directories
+}
+
+// MediaFile is a discovered media file. Root is the directory argument it
+// was found under, or "" if the file was named directly. Options carries
+// per-file settings from an input list, or nil.
+type MediaFile struct {
+	Path    string
+	Root    string
+	Options *FileOptions
+}
+
+// FileOptions are per-file settings given alongside a path in an input list.
+type FileOptions struct {
+	Language   string // whisper language code; "" = use --language
+	AudioTrack int    // audio stream index; -1 = use --audio-track
+}
+
+// ListEntry is one path read from an input list.
+type ListEntry struct {
+	Path    string
+	Options *FileOptions
+}
+
+// ReadPathList reads paths from r for --from-file and "-". If the input
+// contains a NUL byte it is treated as NUL-delimited (as produced by
+// "find -print0") and taken verbatim. Otherwise it is read line by line:
+// blank lines and lines starting with "#" are ignored, and a path may be
+// followed by a tab and space-separated per-file options:
+//
+//	/media/interview.mkv	language=ru audio-track=2
+func ReadPathList(r io.Reader) ([]ListEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ListEntry
+	if bytes.IndexByte(data, 0) >= 0 {
+		for _, p := range strings.Split(string(data), "\x00") {
+			if p != "" {
+				entries = append(entries, ListEntry{Path: p})
+			}
+		}
+		return entries, nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path, opts, hasOpts := strings.Cut(line, "\t")
+		entry := ListEntry{Path: path}
+		if hasOpts {
+			fo, err := parseFileOptions(opts)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			entry.Options = fo
+		}
+		entries = append(entries, entry)
+	}
+	return entries, sc.Err()
+}
+
+// parseFileOptions parses space-separated key=value per-file options.
+func parseFileOptions(s string) (*FileOptions, error) {
+	fo := &FileOptions{AudioTrack: -1}
+	for _, field := range strings.Fields(s) {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("option %q is not key=value", field)
+		}
+		switch key {
+		case "language", "l":
+			lang, err := WhisperLanguage(val)
+			if err != nil {
+				return nil, err
+			}
+			fo.Language = lang
+		case "audio-track", "a":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid audio-track %q", val)
+			}
+			fo.AudioTrack = n
+		default:
+			return nil, fmt.Errorf("unknown option %q (supported: language, audio-track)", key)
+		}
+	}
+	return fo, nil
+}
+
+// RelDir returns the directory of the file relative to its Root, or "." if
+// it was named directly or sits at the top of its Root.
+func (f MediaFile) RelDir() string {
+	if f.Root == "" {
+		return "."
+	}
+	rel, err := filepath.Rel(f.Root, filepath.Dir(f.Path))
+	if err != nil {
+		return "."
+	}
+	return rel
 }
 
 // FindMediaFiles returns all media files found in the given paths.
 // Each path can be a direct file or a directory (contents are listed sorted).
+// Files with unfamiliar extensions are included if their content is media.
 // Non-existent or unrecognised paths produce a warning on stderr.
 func FindMediaFiles(paths []string) []string {
 	var found []string
-	for _, p := range paths {
+	for _, f := range DiscoverMediaFiles(paths, DiscoverOptions{}) {
+		found = append(found, f.Path)
+	}
+	return found
+}
+
+// DiscoverMediaFiles is FindMediaFiles with control over recursion,
+// filtering and symlink handling. Files named directly are always included
+// if they are media; include/exclude patterns only apply to directory
+// contents, and the hidden filter only to recursive walks.
+//
+// Glob patterns use filepath.Match syntax and are matched against both the
+// entry's base name and its path relative to the directory argument, so
+// "*.mkv" and "Season */*.mkv" both work.
+func DiscoverMediaFiles(paths []string, opts DiscoverOptions) []MediaFile {
+	entries := make([]ListEntry, len(paths))
+	for i, p := range paths {
+		entries[i] = ListEntry{Path: p}
+	}
+	return DiscoverListedFiles(entries, opts)
+}
+
+// DiscoverListedFiles is DiscoverMediaFiles for entries of an input list;
+// each entry's options are attached to every media file found through it.
+func DiscoverListedFiles(entries []ListEntry, opts DiscoverOptions) []MediaFile {
+	var found []MediaFile
+	for _, entry := range entries {
+		p := entry.Path
 		info, err := os.Stat(p)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: skipping '%s' (not a file or directory)\n", p)
 			continue
 		}
 		if info.Mode().IsRegular() {
-			if MediaExts[strings.ToLower(filepath.Ext(p))] {
-				found = append(found, p)
+			if isMediaPath(p) {
+				found = append(found, MediaFile{Path: p, Options: entry.Options})
 			}
 			continue
 		}
 		if info.IsDir() {
-			entries, err := os.ReadDir(p)
+			w := &dirWalker{root: p, opts: opts, visited: map[string]bool{}}
+			w.walk(p, 1)
+			for _, f := range w.found {
+				f.Options = entry.Options
+				found = append(found, f)
+			}
+			continue
+		}
+		// Not a regular file and not a directory (e.g. device, socket, etc.)
+		fmt.Fprintf(os.Stderr, "Warning: skipping '%s' (not a file or directory)\n", p)
+	}
+	return found
+}
+
+// dirWalker collects media files below one directory argument.
+type dirWalker struct {
+	root    string
+	opts    DiscoverOptions
+	visited map[string]bool // resolved directories, to break symlink loops
+	found   []MediaFile
+}
+
+// walk lists dir (at the given depth, 1 = the root) and recurses into
+// subdirectories as the options allow.
+func (w *dirWalker) walk(dir string, depth int) {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if w.visited[real] {
+			return
+		}
+		w.visited[real] = true
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping '%s' (%v)\n", dir, err)
+		return
+	}
+	// os.ReadDir returns entries sorted by name already, but
+	// we sort explicitly to match the Python behaviour exactly.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(dir, name)
+		// Dot-files are only skipped in recursive walks; a plain directory
+		// argument lists everything in it, as it always has.
+		if w.opts.Recursive && !w.opts.Hidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+		rel, _ := filepath.Rel(w.root, path)
+		if matchAny(w.opts.Exclude, name, rel) {
+			continue
+		}
+
+		mode := e.Type()
+		if mode&os.ModeSymlink != 0 {
+			if !w.opts.FollowSymlinks {
+				continue
+			}
+			info, err := os.Stat(path)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: skipping '%s' (%v)\n", p, err)
+				continue // dangling link
+			}
+			mode = info.Mode().Type()
+		}
+
+		switch {
+		case mode.IsRegular():
+			// Check the include filter first so excluded files are not sniffed.
+			if len(w.opts.Include) > 0 && !matchAny(w.opts.Include, name, rel) {
 				continue
 			}
-			// os.ReadDir returns entries sorted by name already, but
-			// we sort explicitly to match the Python behaviour exactly.
-			names := make([]string, 0, len(entries))
-			for _, e := range entries {
-				if e.Type().IsRegular() {
-					names = append(names, e.Name())
-				}
+			if !isMediaPath(path) {
+				continue
 			}
-			sort.Strings(names)
-			for _, name := range names {
-				if MediaExts[strings.ToLower(filepath.Ext(name))] {
-					found = append(found, filepath.Join(p, name))
-				}
+			w.found = append(w.found, MediaFile{Path: path, Root: w.root})
+		case mode.IsDir():
+			if w.opts.Recursive && (w.opts.MaxDepth <= 0 || depth < w.opts.MaxDepth) {
+				w.walk(path, depth+1)
 			}
-			continue
 		}
-		// Not a regular file and not a directory (e.g. symlink, device, etc.)
-		fmt.Fprintf(os.Stderr, "Warning: skipping '%s' (not a file or directory)\n", p)
 	}
-	return found
+}
+
+// matchAny reports whether name or rel matches any of the glob patterns.
+// Malformed patterns never match.
+func matchAny(patterns []string, name, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pat := range patterns {
+		if ok, _ := filepath.Match(pat, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(filepath.ToSlash(pat), rel); ok {
+			return true
+		}
+	}
+	return false
 }
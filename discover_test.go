@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -105,3 +106,293 @@ func TestFindMediaFiles_AudioFile(t *testing.T) {
 		t.Errorf("expected %q, got %q", wav, got[0])
 	}
 }
+
+// helper: create a nested directory tree and return the path of dir/sub.
+func mkdirAll(t *testing.T, dir string, parts ...string) string {
+	t.Helper()
+	p := filepath.Join(append([]string{dir}, parts...)...)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// paths extracts the Path of each discovered file.
+func paths(files []MediaFile) []string {
+	out := make([]string, len(files))
+	for i, f := range files {
+		out[i] = f.Path
+	}
+	return out
+}
+
+func TestFindMediaFiles_NotRecursiveByDefault(t *testing.T) {
+	dir := t.TempDir()
+	top := touchFile(t, dir, "top.mkv")
+	touchFile(t, mkdirAll(t, dir, "Season 1"), "ep1.mkv")
+
+	got := FindMediaFiles([]string{dir})
+	if len(got) != 1 || got[0] != top {
+		t.Errorf("expected only %q, got %v", top, got)
+	}
+}
+
+func TestDiscoverMediaFiles_Recursive(t *testing.T) {
+	dir := t.TempDir()
+	a := touchFile(t, dir, "a.mkv")
+	s1 := mkdirAll(t, dir, "Show", "Season 1")
+	e1 := touchFile(t, s1, "ep1.mkv")
+	e2 := touchFile(t, s1, "ep2.mkv")
+	z := touchFile(t, dir, "z.mp4")
+
+	got := paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{Recursive: true}))
+	// Entries are visited in byte order, so "Show" sorts before "a.mkv".
+	want := []string{e1, e2, a, z}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestDiscoverMediaFiles_MaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	show := mkdirAll(t, dir, "Show")
+	shallow := touchFile(t, show, "extra.mkv")
+	touchFile(t, mkdirAll(t, show, "Season 1"), "ep1.mkv")
+
+	got := paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{Recursive: true, MaxDepth: 2}))
+	if len(got) != 1 || got[0] != shallow {
+		t.Errorf("expected only %q, got %v", shallow, got)
+	}
+}
+
+func TestDiscoverMediaFiles_IncludeExclude(t *testing.T) {
+	dir := t.TempDir()
+	keep := touchFile(t, dir, "movie.mkv")
+	touchFile(t, dir, "movie.mp4")
+	touchFile(t, dir, "sample.mkv")
+	touchFile(t, mkdirAll(t, dir, "Extras"), "bonus.mkv")
+
+	got := paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{
+		Recursive: true,
+		Include:   []string{"*.mkv"},
+		Exclude:   []string{"sample*", "Extras"},
+	}))
+	if len(got) != 1 || got[0] != keep {
+		t.Errorf("expected only %q, got %v", keep, got)
+	}
+}
+
+func TestDiscoverMediaFiles_RelativePathGlob(t *testing.T) {
+	dir := t.TempDir()
+	want := touchFile(t, mkdirAll(t, dir, "Season 2"), "ep1.mkv")
+	touchFile(t, mkdirAll(t, dir, "Specials"), "ep1.mkv")
+
+	got := paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{
+		Recursive: true,
+		Include:   []string{"Season */*.mkv"},
+	}))
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("expected only %q, got %v", want, got)
+	}
+}
+
+func TestDiscoverMediaFiles_Hidden(t *testing.T) {
+	dir := t.TempDir()
+	visible := touchFile(t, dir, "movie.mkv")
+	hidden := touchFile(t, dir, "._movie.mkv")
+	inHiddenDir := touchFile(t, mkdirAll(t, dir, ".trash"), "old.mkv")
+
+	got := paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{Recursive: true}))
+	if len(got) != 1 || got[0] != visible {
+		t.Errorf("expected only %q, got %v", visible, got)
+	}
+
+	got = paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{Recursive: true, Hidden: true}))
+	want := []string{hidden, inHiddenDir, visible}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("with Hidden: got %v; want %v", got, want)
+	}
+
+	got = paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{}))
+	want = []string{hidden, visible}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("without Recursive: got %v; want %v", got, want)
+	}
+}
+
+func TestDiscoverMediaFiles_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	other := t.TempDir()
+	touchFile(t, other, "linked.mkv")
+	if err := os.Symlink(other, filepath.Join(dir, "library")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	// A link back to the root must not cause an endless walk.
+	os.Symlink(dir, filepath.Join(other, "loop"))
+
+	if got := DiscoverMediaFiles([]string{dir}, DiscoverOptions{Recursive: true}); len(got) != 0 {
+		t.Errorf("symlinks should not be followed by default, got %v", paths(got))
+	}
+
+	got := paths(DiscoverMediaFiles([]string{dir}, DiscoverOptions{Recursive: true, FollowSymlinks: true}))
+	want := filepath.Join(dir, "library", "linked.mkv")
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("expected only %q, got %v", want, got)
+	}
+}
+
+func TestMediaFile_RelDir(t *testing.T) {
+	root := filepath.Join("lib", "Show")
+	f := MediaFile{Path: filepath.Join(root, "Season 1", "ep1.mkv"), Root: root}
+	if got := f.RelDir(); got != "Season 1" {
+		t.Errorf("RelDir() = %q; want %q", got, "Season 1")
+	}
+	if got := (MediaFile{Path: "movie.mkv"}).RelDir(); got != "." {
+		t.Errorf("RelDir() for direct file = %q; want %q", got, ".")
+	}
+}
+
+// stubSniff replaces content sniffing for the duration of the test, treating
+// files named in media as media and recording every sniffed path.
+func stubSniff(t *testing.T, media ...string) *[]string {
+	t.Helper()
+	var sniffed []string
+	orig := sniffMedia
+	sniffMedia = func(path string) bool {
+		sniffed = append(sniffed, filepath.Base(path))
+		for _, m := range media {
+			if filepath.Base(path) == m {
+				return true
+			}
+		}
+		return false
+	}
+	t.Cleanup(func() { sniffMedia = orig })
+	return &sniffed
+}
+
+func TestFindMediaFiles_NewContainerExts(t *testing.T) {
+	dir := t.TempDir()
+	stubSniff(t)
+	var want []string
+	for _, name := range []string{"a.aac", "b.flv", "c.m2ts", "d.m4a", "e.mpg", "f.ogg", "g.opus", "h.ts", "i.wma"} {
+		want = append(want, touchFile(t, dir, name))
+	}
+
+	got := FindMediaFiles([]string{dir})
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestFindMediaFiles_SniffsUnknownExtensions(t *testing.T) {
+	dir := t.TempDir()
+	sniffed := stubSniff(t, "capture.dat", "recording")
+	capture := touchFile(t, dir, "capture.dat")
+	touchFile(t, dir, "notes.bin")
+	touchFile(t, dir, "movie.srt")
+	for _, companion := range []string{"movie.srt.partial", "movie.eng.review.html", "movie.ttml", "ggml-base.bin.sha256", "ggml-base.bin.lock"} {
+		touchFile(t, dir, companion)
+	}
+	noExt := touchFile(t, dir, "recording")
+	touchFile(t, dir, "video.mp4")
+
+	got := FindMediaFiles([]string{dir})
+	want := []string{capture, noExt, filepath.Join(dir, "video.mp4")}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// Known media and known companion extensions are decided without sniffing.
+	if strings.Join(*sniffed, "|") != "capture.dat|notes.bin|recording" {
+		t.Errorf("sniffed %v; want only unknown extensions", *sniffed)
+	}
+}
+
+func TestFindMediaFiles_SniffsDirectFile(t *testing.T) {
+	dir := t.TempDir()
+	stubSniff(t, "stream.dump")
+	f := touchFile(t, dir, "stream.dump")
+
+	got := FindMediaFiles([]string{f})
+	if len(got) != 1 || got[0] != f {
+		t.Errorf("expected %q, got %v", f, got)
+	}
+}
+
+func TestReadPathList_Lines(t *testing.T) {
+	in := "# job manifest\n/a/one.mkv\r\n\n/b/two words.mp4\n"
+	got, err := ReadPathList(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadPathList returned error: %v", err)
+	}
+	if len(got) != 2 || got[0].Path != "/a/one.mkv" || got[1].Path != "/b/two words.mp4" {
+		t.Errorf("got %+v", got)
+	}
+	if got[0].Options != nil {
+		t.Errorf("expected no options, got %+v", got[0].Options)
+	}
+}
+
+func TestReadPathList_NUL(t *testing.T) {
+	in := "./a.mkv\x00./odd\nname.mp4\x00# not a comment.mp4\x00"
+	got, err := ReadPathList(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadPathList returned error: %v", err)
+	}
+	want := []string{"./a.mkv", "./odd\nname.mp4", "# not a comment.mp4"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Path != want[i] {
+			t.Errorf("entry %d = %q; want %q", i, got[i].Path, want[i])
+		}
+	}
+}
+
+func TestReadPathList_Options(t *testing.T) {
+	in := "/a/interview.mkv\tlanguage=rus audio-track=2\n/a/plain.mkv\n"
+	got, err := ReadPathList(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadPathList returned error: %v", err)
+	}
+	o := got[0].Options
+	if o == nil || o.Language != "ru" || o.AudioTrack != 2 {
+		t.Errorf("options = %+v; want language=ru audio-track=2", o)
+	}
+	if got[1].Options != nil {
+		t.Errorf("expected no options for plain entry, got %+v", got[1].Options)
+	}
+}
+
+func TestReadPathList_BadOptions(t *testing.T) {
+	for _, in := range []string{
+		"/a.mkv\tlanguage=klingon\n",
+		"/a.mkv\taudio-track=x\n",
+		"/a.mkv\tmodel=large\n",
+		"/a.mkv\tverbose\n",
+	} {
+		if _, err := ReadPathList(strings.NewReader(in)); err == nil {
+			t.Errorf("ReadPathList(%q) should return an error", in)
+		}
+	}
+}
+
+func TestDiscoverListedFiles_AttachesOptions(t *testing.T) {
+	dir := t.TempDir()
+	f := touchFile(t, dir, "a.mkv")
+	touchFile(t, dir, "b.mkv")
+	opts := &FileOptions{Language: "de", AudioTrack: -1}
+
+	got := DiscoverListedFiles([]ListEntry{{Path: f, Options: opts}, {Path: dir}}, DiscoverOptions{})
+	if len(got) != 3 {
+		t.Fatalf("expected 3 files, got %d: %v", len(got), paths(got))
+	}
+	if got[0].Options != opts {
+		t.Errorf("direct file should carry its entry's options")
+	}
+	if got[1].Options != nil || got[2].Options != nil {
+		t.Errorf("directory contents should carry the directory entry's (nil) options")
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// retryBackoff is the wait before the first retry of a failed request. It
+// doubles with every further retry, up to maxRetryBackoff. Tests shorten it.
+var retryBackoff = time.Second
+
+const maxRetryBackoff = time.Minute
+
+// minSegmentSize is the smallest range worth its own connection. Tests
+// shrink it.
+var minSegmentSize int64 = 8 << 20
+
+// errRestart reports that the server cannot continue a partial download,
+// so it has to start over from the first byte.
+var errRestart = errors.New("server does not support resuming")
+
+// permanentError is a download failure that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// addDownloadFlags defines the flags controlling model downloads on fs.
+func addDownloadFlags(fs *flag.FlagSet, opts *ModelOptions) {
+	fs.IntVar(&opts.Retries, "download-retries", 5, "Retries of an interrupted model download")
+	fs.IntVar(&opts.Connections, "download-connections", 1, "Parallel connections for a model download")
+	fs.DurationVar(&opts.StallTimeout, "download-stall-timeout", time.Minute, "Abort a model download request receiving no data for this long")
+}
+
+// printDownloadUsage prints the help for the flags of addDownloadFlags.
+func printDownloadUsage(w io.Writer) {
+	fmt.Fprintf(w, "\nModel download:\n")
+	fmt.Fprintf(w, "      --download-retries int\n")
+	fmt.Fprintf(w, "                           Retries of an interrupted download, resuming where it stopped (default 5)\n")
+	fmt.Fprintf(w, "      --download-connections int\n")
+	fmt.Fprintf(w, "                           Parallel connections for one download (default 1)\n")
+	fmt.Fprintf(w, "      --download-stall-timeout duration\n")
+	fmt.Fprintf(w, "                           Retry a request receiving no data for this long, 0 = never (default 1m0s)\n")
+}
+
+// checkDownloadOptions validates the values of the download flags.
+func checkDownloadOptions(opts ModelOptions) error {
+	if opts.Retries < 0 || opts.Connections < 1 || opts.StallTimeout < 0 {
+		return errors.New("--download-retries and --download-stall-timeout must not be negative, --download-connections must be at least 1")
+	}
+	return nil
+}
+
+// download is one model file being fetched into a temporary file.
+type download struct {
+	loc  modelMirror
+	name string
+	opts ModelOptions
+
+	lock    *downloadLock // told the file size, for other processes waiting
+	client  *http.Client
+	failed  atomic.Bool // a range gave up; the others stop retrying
+	mu      sync.Mutex
+	total   int64  // file size, once known; 0 = unknown
+	sha256  string // digest advertised by the server
+	done    int64  // bytes in the temporary file, for progress
+	percent int
+	started bool
+}
+
+// segment is a range [start, end) of the file fetched over one connection;
+// end < 0 means up to the end of the file. pos is how far it got.
+type segment struct {
+	start, pos, end int64
+}
+
+// downloadModel fetches the model from url and writes it to dest,
+// printing progress to stderr. Data goes to a temporary file with a unique
+// name first, which is kept when the download fails, so retries (and later
+// runs) resume it with Range requests instead of starting over. The file
+// is checked against pinned, the model's pinned checksum, or else the one
+// the server advertises, before it atomically replaces dest, and that
+// checksum is recorded next to it in dest+".sha256" for later
+// verification. Without either, the file is kept unverified and nothing is
+// recorded. The caller should hold the download lock of dest, if any;
+// the file size is recorded in it.
+func downloadModel(loc modelMirror, dest, name, pinned string, opts ModelOptions, lock *downloadLock) error {
+	d := &download{loc: loc, name: name, opts: opts, lock: lock}
+	d.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			// Hugging Face advertises the digest on the redirect to its CDN.
+			d.learn(req.Response, 0)
+			return nil
+		},
+	}
+
+	f, err := claimPartial(dest)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer f.Close()
+	tmpPath := f.Name()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	have := info.Size()
+	d.done = have
+	if have > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming download of model '%s' after %d MB...\n", name, have/(1024*1024))
+	}
+
+	segments := d.plan(have)
+	if err := d.fetchAll(f, segments); err != nil {
+		// Keep what arrived in one piece from the start for the next try.
+		prefix := segments[0].start
+		for _, s := range segments {
+			prefix = s.pos
+			if s.pos != s.end {
+				break
+			}
+		}
+		f.Truncate(prefix)
+		return err
+	}
+
+	info, err = f.Stat()
+	if err != nil {
+		return err
+	}
+	if d.total > 0 && info.Size() != d.total {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("download incomplete: got %d of %d bytes", info.Size(), d.total)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+
+	want := pinned
+	if want == "" {
+		want = d.sha256
+	}
+	sum, err := fileSHA256(tmpPath)
+	if err != nil {
+		return err
+	}
+	if want != "" && sum != want {
+		// Do not resume a corrupted file.
+		os.Remove(tmpPath)
+		return fmt.Errorf("checksum mismatch: got %s, want %s", sum, want)
+	}
+
+	// Rename temp file to final destination. A checksum is only recorded
+	// if the file was checked against one; the file's own digest would
+	// make any later verification pass.
+	if err := os.Rename(tmpPath, dest); err != nil {
+		return fmt.Errorf("moving temp file: %w", err)
+	}
+	if want == "" {
+		os.Remove(dest + ".sha256")
+		fmt.Fprintf(os.Stderr, "Model '%s' downloaded, unverified: no checksum is known for it.\n", name)
+		return nil
+	}
+	if err := os.WriteFile(dest+".sha256", []byte(sum+"\n"), 0644); err != nil {
+		return fmt.Errorf("recording checksum: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Model '%s' downloaded successfully.\n", name)
+	return nil
+}
+
+// claimPartial opens a new temporary file for downloading dest. If an
+// earlier download of it was interrupted, its data is moved there to be
+// resumed; other leftover partial downloads are removed.
+func claimPartial(dest string) (*os.File, error) {
+	var resume string
+	var size int64 = -1
+	for _, p := range partialDownloads(dest) {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.Size() > size {
+			if resume != "" {
+				os.Remove(resume)
+			}
+			resume, size = p, info.Size()
+		} else {
+			os.Remove(p)
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".download-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if resume == "" {
+		return f, nil
+	}
+	f.Close()
+	if err := os.Rename(resume, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return os.OpenFile(f.Name(), os.O_RDWR, 0644)
+}
+
+// plan splits the rest of the file after the first have bytes into ranges.
+// Without --download-connections, or if the server does not say how large
+// the file is and that it accepts ranges, there is a single open range.
+func (d *download) plan(have int64) []*segment {
+	single := []*segment{{start: have, pos: have, end: -1}}
+	if d.opts.Connections <= 1 {
+		return single
+	}
+	req, err := d.request(context.Background(), http.MethodHead)
+	if err != nil {
+		return single
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return single
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength <= have {
+		return single
+	}
+	d.learn(resp, 0)
+
+	n := int64(d.opts.Connections)
+	n = min(n, (resp.ContentLength-have)/minSegmentSize)
+	if n <= 1 {
+		return single
+	}
+	var segments []*segment
+	size := (resp.ContentLength - have) / n
+	for i := int64(0); i < n; i++ {
+		start, end := have+i*size, have+(i+1)*size
+		if i == n-1 {
+			end = resp.ContentLength
+		}
+		segments = append(segments, &segment{start: start, pos: start, end: end})
+	}
+	return segments
+}
+
+// request creates a request for the file, authenticated with the bearer
+// token if there is one. The client drops the token on redirects to
+// other hosts.
+func (d *download) request(ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.loc.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.loc.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.loc.Token)
+	}
+	return req, nil
+}
+
+// fetchAll downloads all segments concurrently and returns the first error.
+func (d *download) fetchAll(f *os.File, segments []*segment) error {
+	errs := make([]error, len(segments))
+	var wg sync.WaitGroup
+	for i, s := range segments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = d.fetchSegment(f, s); errs[i] != nil {
+				d.failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// fetchSegment downloads one segment, retrying with exponential backoff
+// and continuing from where the previous attempt stopped.
+func (d *download) fetchSegment(f *os.File, s *segment) error {
+	for attempt := 0; ; attempt++ {
+		n, err := d.fetchRange(f, s.pos, s.end)
+		s.pos += n
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errRestart) && s.end < 0 && s.pos > 0 {
+			fmt.Fprintf(os.Stderr, "Server cannot resume the download, starting over.\n")
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			s.start, s.pos = 0, 0
+			d.mu.Lock()
+			d.done, d.percent = 0, 0
+			d.mu.Unlock()
+			continue
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= d.opts.Retries || d.failed.Load() {
+			return err
+		}
+		wait := retryBackoff
+		for i := 0; i < attempt && wait < maxRetryBackoff; i++ {
+			wait *= 2
+		}
+		wait = min(wait, maxRetryBackoff)
+		fmt.Fprintf(os.Stderr, "Download interrupted (%v), retrying in %s (attempt %d of %d)...\n", err, wait, attempt+2, d.opts.Retries+1)
+		time.Sleep(wait)
+	}
+}
+
+// fetchRange downloads bytes [start, end) of the file (end < 0: to the end)
+// and writes them into f at the same offsets. It returns how many bytes
+// were written, also when it fails partway. A request receiving no data
+// for the stall timeout is aborted.
+func (d *download) fetchRange(f *os.File, start, end int64) (int64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var timer *time.Timer
+	var stalled atomic.Bool
+	if d.opts.StallTimeout > 0 {
+		timer = time.AfterFunc(d.opts.StallTimeout, func() {
+			stalled.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	stallErr := func(err error) error {
+		if stalled.Load() {
+			return fmt.Errorf("download stalled: no data for %s", d.opts.StallTimeout)
+		}
+		return err
+	}
+
+	req, err := d.request(ctx, http.MethodGet)
+	if err != nil {
+		return 0, permanentError{err}
+	}
+	if start > 0 || end >= 0 {
+		r := "bytes=" + strconv.FormatInt(start, 10) + "-"
+		if end >= 0 {
+			r += strconv.FormatInt(end-1, 10)
+		}
+		req.Header.Set("Range", r)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, stallErr(fmt.Errorf("HTTP request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && start == 0 && end < 0:
+	case resp.StatusCode == http.StatusOK:
+		return 0, errRestart
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && end < 0:
+		// Nothing left to fetch if the file already has all the bytes.
+		if total, ok := rangeTotal(resp.Header.Get("Content-Range")); ok && total == start {
+			d.learn(nil, total)
+			return 0, nil
+		}
+		return 0, errRestart
+	default:
+		err := fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			err = fmt.Errorf("server returned HTTP %d; check the mirror's token", resp.StatusCode)
+		}
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return 0, permanentError{err}
+		}
+		return 0, err
+	}
+	total, _ := rangeTotal(resp.Header.Get("Content-Range"))
+	if total == 0 && resp.ContentLength > 0 && end < 0 {
+		total = start + resp.ContentLength
+	}
+	d.learn(resp, total)
+	d.announce()
+
+	buf := make([]byte, 64*1024)
+	var n int64
+	for {
+		m, rerr := resp.Body.Read(buf)
+		if m > 0 {
+			if timer != nil {
+				timer.Reset(d.opts.StallTimeout)
+			}
+			if _, err := f.WriteAt(buf[:m], start+n); err != nil {
+				return n, permanentError{fmt.Errorf("writing model data: %w", err)}
+			}
+			n += int64(m)
+			d.advance(int64(m))
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return n, stallErr(fmt.Errorf("reading model data: %w", rerr))
+		}
+	}
+	if end >= 0 && start+n < end {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// rangeTotal returns the complete length from a Content-Range header such
+// as "bytes 100-199/1000" or "bytes */1000".
+func rangeTotal(header string) (int64, bool) {
+	i := strings.LastIndexByte(header, '/')
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(header[i+1:], 10, 64)
+	return n, err == nil && n > 0
+}
+
+// learn records what a response tells about the file: its total size (if
+// total > 0) and an advertised SHA-256 digest (if resp is non-nil).
+func (d *download) learn(resp *http.Response, total int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if resp != nil && d.sha256 == "" {
+		d.sha256 = advertisedSHA256(resp)
+	}
+	if total > 0 && d.total == 0 {
+		d.total = total
+		d.lock.setTotal(total)
+	}
+}
+
+// announce prints that the download started, once.
+func (d *download) announce() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started {
+		return
+	}
+	d.started = true
+	if d.total > 0 {
+		fmt.Fprintf(os.Stderr, "Downloading model '%s' (%d MB)...\n", d.name, d.total/(1024*1024))
+		d.percent = int(d.done * 100 / d.total)
+	} else {
+		fmt.Fprintf(os.Stderr, "Downloading model '%s'...\n", d.name)
+	}
+}
+
+// advance adds n downloaded bytes and prints progress at every 10%.
+func (d *download) advance(n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.done += n
+	if d.total <= 0 {
+		return
+	}
+	percent := int(d.done * 100 / d.total)
+	if percent/10 > d.percent/10 {
+		fmt.Fprintf(os.Stderr, "  %d%%\n", percent)
+		d.percent = percent
+	}
+}
+
+// advertisedSHA256 returns the SHA-256 digest the server gives for a
+// download in its X-Linked-Etag header, or "" if there is none.
+func advertisedSHA256(resp *http.Response) string {
+	etag := strings.Trim(resp.Header.Get("X-Linked-Etag"), `"`)
+	if len(etag) != 64 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
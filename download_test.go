@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves data with Range support. Before serving, each request
+// goes through hook, which may write a response itself and return false.
+func rangeServer(t *testing.T, data []byte, hook func(call int, w http.ResponseWriter, r *http.Request) bool) (url string, ranges *[]string) {
+	var mu sync.Mutex
+	var seen []string
+	call := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		call++
+		n := call
+		if r.Method == http.MethodGet {
+			seen = append(seen, r.Header.Get("Range"))
+		}
+		mu.Unlock()
+		if hook != nil && !hook(n, w, r) {
+			return
+		}
+		http.ServeContent(w, r, "model.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	orig := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = orig })
+	return srv.URL + "/ggml-test.bin", &seen
+}
+
+// dropAfter writes the response headers and the first n bytes of data,
+// then cuts the connection.
+func dropAfter(w http.ResponseWriter, data []byte, n int) {
+	w.WriteHeader(http.StatusOK)
+	w.Write(data[:n])
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func testModelData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestDownloadModel_ResumesAfterDrop(t *testing.T) {
+	data := testModelData(100000)
+	url, ranges := rangeServer(t, data, func(call int, w http.ResponseWriter, r *http.Request) bool {
+		if call == 1 {
+			dropAfter(w, data, 30000)
+		}
+		return true
+	})
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+
+	if err := downloadModel(modelMirror{URL: url}, dest, "test", "", ModelOptions{Retries: 2}, nil); err != nil {
+		t.Fatalf("downloadModel: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, not the served data", len(got))
+	}
+	if want := []string{"", "bytes=30000-"}; strings.Join(*ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("requested ranges %q; want %q", *ranges, want)
+	}
+	if p := partialDownloads(dest); len(p) > 0 {
+		t.Errorf("temporary files left behind: %q", p)
+	}
+}
+
+func TestDownloadModel_ResumesEarlierRun(t *testing.T) {
+	data := testModelData(100000)
+	url, ranges := rangeServer(t, data, func(call int, w http.ResponseWriter, r *http.Request) bool {
+		dropAfter(w, data, 40000)
+		return false
+	})
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+
+	if err := downloadModel(modelMirror{URL: url}, dest, "test", "", ModelOptions{}, nil); err == nil {
+		t.Fatal("downloadModel succeeded on a dropped connection without retries")
+	}
+	if p := partialDownloads(dest); len(p) != 1 || partialSize(dest) != 40000 {
+		t.Fatalf("temporary files after failure: %q of %d bytes; want one with the 40000 bytes received", p, partialSize(dest))
+	}
+
+	url2, ranges2 := rangeServer(t, data, nil)
+	if err := downloadModel(modelMirror{URL: url2}, dest, "test", "", ModelOptions{}, nil); err != nil {
+		t.Fatalf("second downloadModel: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("resumed download differs from the served data")
+	}
+	if len(*ranges) != 1 || strings.Join(*ranges2, ",") != "bytes=40000-" {
+		t.Errorf("requested ranges %q then %q; want one full request, then bytes=40000-", *ranges, *ranges2)
+	}
+}
+
+func TestDownloadModel_RestartsWithoutRangeSupport(t *testing.T) {
+	data := testModelData(50000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data) // ignores Range
+	}))
+	defer srv.Close()
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	os.WriteFile(dest+".tmp", data[:20000], 0644)
+
+	if err := downloadModel(modelMirror{URL: srv.URL + "/ggml-test.bin"}, dest, "test", "", ModelOptions{}, nil); err != nil {
+		t.Fatalf("downloadModel: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, not the served data", len(got))
+	}
+}
+
+func TestDownloadModel_Segmented(t *testing.T) {
+	defer func(n int64) { minSegmentSize = n }(minSegmentSize)
+	minSegmentSize = 10000
+	data := testModelData(100000)
+	var dropped sync.Once
+	url, ranges := rangeServer(t, data, func(call int, w http.ResponseWriter, r *http.Request) bool {
+		// Cut the first segment short once; it must resume on its own.
+		drop := false
+		if r.Header.Get("Range") == "bytes=0-24999" {
+			dropped.Do(func() { drop = true })
+		}
+		if drop {
+			w.Header().Set("Content-Range", "bytes 0-24999/100000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:5000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		return true
+	})
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+
+	if err := downloadModel(modelMirror{URL: url}, dest, "test", "", ModelOptions{Retries: 1, Connections: 4}, nil); err != nil {
+		t.Fatalf("downloadModel: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("segmented download differs from the served data")
+	}
+	got := strings.Join(*ranges, ",")
+	for _, want := range []string{"bytes=0-24999", "bytes=5000-24999", "bytes=25000-49999", "bytes=50000-74999", "bytes=75000-99999"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("requested ranges %q; missing %q", got, want)
+		}
+	}
+}
+
+func TestDownloadModel_StallTimeout(t *testing.T) {
+	data := testModelData(100000)
+	url, ranges := rangeServer(t, data, func(call int, w http.ResponseWriter, r *http.Request) bool {
+		if call == 1 {
+			w.Header().Set("Content-Length", "100000")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data[:10000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done() // hang until the client gives up
+			return false
+		}
+		return true
+	})
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+
+	opts := ModelOptions{Retries: 1, StallTimeout: 50 * time.Millisecond}
+	if err := downloadModel(modelMirror{URL: url}, dest, "test", "", opts, nil); err != nil {
+		t.Fatalf("downloadModel: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("download after a stall differs from the served data")
+	}
+	if want := "bytes=10000-"; len(*ranges) != 2 || (*ranges)[1] != want {
+		t.Errorf("requested ranges %q; want a retry with %q", *ranges, want)
+	}
+}
+
+func TestDownloadModel_NoRetryOnNotFound(t *testing.T) {
+	url, ranges := rangeServer(t, nil, func(call int, w http.ResponseWriter, r *http.Request) bool {
+		http.NotFound(w, r)
+		return false
+	})
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+
+	if err := downloadModel(modelMirror{URL: url}, dest, "test", "", ModelOptions{Retries: 3}, nil); err == nil {
+		t.Fatal("downloadModel succeeded on HTTP 404")
+	}
+	if len(*ranges) != 1 {
+		t.Errorf("%d requests for a missing file; want 1", len(*ranges))
+	}
+}
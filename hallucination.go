@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// What --hallucinations does with suspected hallucinations.
+const (
+	HallucinationsRetry  = "retry"  // decode again at a higher temperature; drop if still suspect
+	HallucinationsDrop   = "drop"   // remove them
+	HallucinationsReport = "report" // keep them, only list them
+	HallucinationsOff    = "off"    // do not look for them
+)
+
+// ValidHallucinationMode reports whether mode is one of the
+// Hallucinations constants.
+func ValidHallucinationMode(mode string) bool {
+	switch mode {
+	case HallucinationsRetry, HallucinationsDrop, HallucinationsReport, HallucinationsOff:
+		return true
+	}
+	return false
+}
+
+const (
+	// maxRepeats is how many consecutive segments may say the same thing
+	// before the further ones are taken for a repetition loop.
+	maxRepeats = 2
+
+	// maxCharsPerSecond is faster than anyone speaks; whisper produces
+	// such segments when it squeezes made-up text into a short span.
+	maxCharsPerSecond = 30
+
+	// minFastChars keeps very short segments, whose timestamps are
+	// imprecise, from counting as too fast.
+	minFastChars = 10
+
+	// silenceRMS is the loudness (about -54 dBFS) below which a segment's
+	// audio is taken for silence.
+	silenceRMS = 0.002
+
+	// retryTemperature is the sampling temperature hallucinated regions
+	// are decoded again with, to shake whisper out of the loop it is in.
+	retryTemperature = 0.6
+)
+
+// boilerplatePhrases are texts whisper is known to invent over silence or
+// music, learnt from the subtitle credits in its training data; they are
+// normalized (see normalizeText). A segment saying little else is taken
+// for a hallucination.
+var boilerplatePhrases = []string{
+	"subtitles by the amara org community",
+	"subtitles by",
+	"subtitled by",
+	"transcribed by",
+	"translated by",
+	"captions by",
+	"thank you for watching",
+	"thanks for watching",
+	"please subscribe",
+	"like and subscribe",
+	"don t forget to subscribe",
+	"see you in the next video",
+	"amara org",
+	"untertitel im auftrag des zdf",
+	"untertitel der amara org community",
+	"sous titrage st 501",
+	"sous titres réalisés par la communauté d amara org",
+	"subtítulos realizados por la comunidad de amara org",
+	"sottotitoli creati dalla comunità amara org",
+	"продолжение следует",
+	"субтитры сделал dimatorzok",
+	"субтитры создавал dimatorzok",
+	"ご視聴ありがとうございました",
+	"字幕由amara org社区提供",
+}
+
+// Hallucination is a segment taken for a hallucination, and what was done
+// about it.
+type Hallucination struct {
+	Segment Segment
+	Reason  string // "repeated", "too fast", "boilerplate" or "over silence"
+	Action  string // "dropped", "re-decoded" or "kept"
+}
+
+// findHallucinations returns, for each segment, why it looks like a
+// hallucination, or "" if it does not. samples is the audio the segments
+// are timed against, or nil to skip the silence check.
+func findHallucinations(segs []Segment, samples []float32) []string {
+	reasons := make([]string, len(segs))
+	for i, seg := range segs {
+		text := normalizeText(seg.Text)
+		if text == "" {
+			continue
+		}
+		run := 1
+		for j := i - 1; j >= 0 && normalizeText(segs[j].Text) == text; j-- {
+			run++
+		}
+		switch {
+		case run > maxRepeats || repetitive(seg.Text):
+			reasons[i] = "repeated"
+		case boilerplate(text):
+			reasons[i] = "boilerplate"
+		case tooFast(seg):
+			reasons[i] = "too fast"
+		case samples != nil && silent(samples, seg.Start, seg.End):
+			reasons[i] = "over silence"
+		}
+	}
+	return reasons
+}
+
+// boilerplate reports whether normalized text is little more than one of
+// the boilerplatePhrases.
+func boilerplate(text string) bool {
+	for _, p := range boilerplatePhrases {
+		if strings.Contains(text, p) && len(text)-len(p) <= len(p)/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// tooFast reports whether a segment has more text than could be spoken in
+// its time.
+func tooFast(seg Segment) bool {
+	n := utf8.RuneCountInString(strings.TrimSpace(seg.Text))
+	if n < minFastChars {
+		return false
+	}
+	d := seg.End - seg.Start
+	return d <= 0 || float64(n)/d.Seconds() > maxCharsPerSecond
+}
+
+// silent reports whether the audio between start and end is below
+// silenceRMS.
+func silent(samples []float32, start, end time.Duration) bool {
+	from := min(int(start*16000/time.Second), len(samples))
+	to := min(int(end*16000/time.Second), len(samples))
+	if to <= from {
+		return false
+	}
+	var sum float64
+	for _, s := range samples[from:to] {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum/float64(to-from)) < silenceRMS
+}
+
+// HandleHallucinations looks for hallucinated segments and deals with them
+// according to mode (one of the Hallucinations constants). With
+// HallucinationsRetry, each region of suspect segments is decoded again by
+// engine at a higher temperature; whatever still looks hallucinated
+// afterwards is dropped. samples is the whole track, which segs are timed
+// against; language is the track's language, unless segments carry their
+// own.
+//
+// It returns the resulting segments and the hallucinations found. Only
+// cancelling ctx is an error; a region that fails to decode is dropped.
+func HandleHallucinations(ctx context.Context, engine Transcriber, samples []float32, segs []Segment, language, mode string) ([]Segment, []Hallucination, error) {
+	if mode == HallucinationsOff {
+		return segs, nil, nil
+	}
+	reasons := findHallucinations(segs, samples)
+	var found []Hallucination
+	var out []Segment
+	for i, seg := range segs {
+		switch {
+		case reasons[i] == "":
+			out = append(out, seg)
+		case mode == HallucinationsReport:
+			found = append(found, Hallucination{Segment: seg, Reason: reasons[i], Action: "kept"})
+			out = append(out, seg)
+		default:
+			found = append(found, Hallucination{Segment: seg, Reason: reasons[i], Action: "dropped"})
+		}
+	}
+	if mode != HallucinationsRetry || len(found) == 0 {
+		return out, found, nil
+	}
+
+	// Decode each run of suspect segments again, keeping what now passes.
+	suspect := make([]bool, len(segs))
+	for i := range segs {
+		suspect[i] = reasons[i] != ""
+	}
+	out = nil
+	next := 0
+	for _, r := range cascadeRegions(segs, suspect, samplesToDuration(len(samples))) {
+		out = append(out, segs[next:r.first]...)
+		next = r.last + 1
+
+		redone, err := transcribeRegion(ctx, engine, samples, r, segs[r.first].Language,
+			TranscribeOptions{Language: language, Temperature: retryTemperature})
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err != nil {
+			continue
+		}
+		// The new text must also not repeat what comes just before it.
+		window := append(out[max(0, len(out)-maxRepeats):len(out):len(out)], redone...)
+		still := findHallucinations(window, samples)[len(window)-len(redone):]
+		kept := false
+		for i, seg := range redone {
+			if still[i] == "" {
+				out = append(out, seg)
+				kept = true
+			}
+		}
+		if kept {
+			for k := range found {
+				if s := found[k].Segment; s.End > r.start && s.Start < r.end {
+					found[k].Action = "re-decoded"
+				}
+			}
+		}
+	}
+	out = append(out, segs[next:]...)
+	return out, found, nil
+}
+
+// maxListedHallucinations caps how many hallucinations are listed per
+// track, so a long repetition loop does not flood the output.
+const maxListedHallucinations = 10
+
+// reportHallucinations prints what HandleHallucinations found.
+func reportHallucinations(w io.Writer, found []Hallucination) {
+	if len(found) == 0 {
+		return
+	}
+	counts := map[string]int{}
+	for _, h := range found {
+		counts[h.Action]++
+	}
+	var parts []string
+	for _, action := range []string{"re-decoded", "dropped", "kept"} {
+		if counts[action] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[action], action))
+		}
+	}
+	fmt.Fprintf(w, "  Hallucinations: %d found (%s)\n", len(found), strings.Join(parts, ", "))
+	for i, h := range found {
+		if i == maxListedHallucinations {
+			fmt.Fprintf(w, "    ... and %d more\n", len(found)-i)
+			break
+		}
+		fmt.Fprintf(w, "    [%s --> %s] %s, %s: %q\n", FormatTimestamp(h.Segment.Start, "vtt"), FormatTimestamp(h.Segment.End, "vtt"),
+			h.Reason, h.Action, truncateText(strings.TrimSpace(h.Segment.Text), 60))
+	}
+}
+
+// truncateText shortens s to at most n runes, marking the cut with "...".
+func truncateText(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-3]) + "..."
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// speech returns n seconds of audio loud enough not to count as silence,
+// with the seconds listed in quiet left silent.
+func speech(n int, quiet ...int) []float32 {
+	samples := make([]float32, n*16000)
+	for i := range samples {
+		samples[i] = 0.1
+	}
+	for _, s := range quiet {
+		clear(samples[s*16000 : (s+1)*16000])
+	}
+	return samples
+}
+
+func TestFindHallucinations(t *testing.T) {
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	segs := []Segment{
+		{Start: sec(0), End: sec(2), Text: "Good evening."},
+		{Start: sec(2), End: sec(3), Text: "Good evening!"},
+		{Start: sec(3), End: sec(4), Text: "good evening"},
+		{Start: sec(4), End: sec(6), Text: strings.Repeat("Thank you. ", 20)},
+		{Start: sec(6), End: sec(8), Text: "Subtitles by the Amara.org community"},
+		{Start: sec(8), End: sec(10), Text: "Thank you for watching, and see you all next week."},
+		{Start: sec(10), End: sec(10.5), Text: "This sentence has far too many characters for its time."},
+		{Start: sec(11), End: sec(11.2), Text: "Yes."},
+		{Start: sec(12), End: sec(14), Text: "Is anyone there?"},
+	}
+	got := findHallucinations(segs, speech(15, 12, 13))
+	want := []string{"", "", "repeated", "repeated", "boilerplate", "", "too fast", "", "over silence"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findHallucinations = %q; want %q", got, want)
+	}
+}
+
+func TestHandleHallucinations(t *testing.T) {
+	segs := []Segment{
+		{Start: 0, End: 2 * time.Second, Text: "Hello there."},
+		{Start: 2 * time.Second, End: 4 * time.Second, Text: "How are you today?"},
+		{Start: 4 * time.Second, End: 6 * time.Second, Text: "How are you today?"},
+		{Start: 6 * time.Second, End: 8 * time.Second, Text: "How are you today?"},
+		{Start: 8 * time.Second, End: 10 * time.Second, Text: "How are you today?"},
+		{Start: 10 * time.Second, End: 12 * time.Second, Text: "Thanks for watching!"},
+		{Start: 12 * time.Second, End: 14 * time.Second, Text: "Fine."},
+		{Start: 16 * time.Second, End: 18 * time.Second, Text: "Okay."},
+	}
+	samples := speech(20, 16, 17)
+	texts := func(segs []Segment) string {
+		var ts []string
+		for _, s := range segs {
+			ts = append(ts, s.Text)
+		}
+		return strings.Join(ts, "|")
+	}
+	actions := func(found []Hallucination) string {
+		var as []string
+		for _, h := range found {
+			as = append(as, h.Reason+" "+h.Action)
+		}
+		return strings.Join(as, "|")
+	}
+
+	tests := []struct {
+		mode    string
+		texts   string
+		actions string
+	}{
+		{HallucinationsOff, texts(segs), ""},
+		{HallucinationsReport, texts(segs), "repeated kept|repeated kept|boilerplate kept|over silence kept"},
+		{HallucinationsDrop, "Hello there.|How are you today?|How are you today?|Fine.",
+			"repeated dropped|repeated dropped|boilerplate dropped|over silence dropped"},
+	}
+	for _, tt := range tests {
+		got, found, err := HandleHallucinations(context.Background(), nil, samples, segs, "en", tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if texts(got) != tt.texts || actions(found) != tt.actions {
+			t.Errorf("%s: got %q, %q; want %q, %q", tt.mode, texts(got), actions(found), tt.texts, tt.actions)
+		}
+	}
+
+	// Retrying decodes the loop again, and drops what still looks made up.
+	var calls cascadeCalls
+	engine := cascadeTranscriber(&calls,
+		[]Segment{{Start: 0, End: 3 * time.Second, Text: "I'm fine, thanks."}},
+		[]Segment{{Start: 0, End: 2 * time.Second, Text: "Okay."}},
+	)
+	got, found, err := HandleHallucinations(context.Background(), engine, samples, segs, "en", HallucinationsRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello there.|How are you today?|How are you today?|I'm fine, thanks.|Fine."; texts(got) != want {
+		t.Errorf("retry: texts %q; want %q", texts(got), want)
+	}
+	if want := "repeated re-decoded|repeated re-decoded|boilerplate re-decoded|over silence dropped"; actions(found) != want {
+		t.Errorf("retry: actions %q; want %q", actions(found), want)
+	}
+	if got[3].Start != 6*time.Second || got[3].End != 9*time.Second {
+		t.Errorf("re-decoded segment at %v-%v; want 6s-9s", got[3].Start, got[3].End)
+	}
+	if want := []time.Duration{6 * time.Second, 2 * time.Second}; !reflect.DeepEqual(calls.lengths, want) {
+		t.Errorf("re-decoded %v of audio per region; want %v", calls.lengths, want)
+	}
+}
+
+func TestReportHallucinations(t *testing.T) {
+	var found []Hallucination
+	for i := range 12 {
+		found = append(found, Hallucination{
+			Segment: Segment{Start: time.Duration(i) * time.Second, End: time.Duration(i+1) * time.Second, Text: " Thanks for watching!"},
+			Reason:  "boilerplate",
+			Action:  "dropped",
+		})
+	}
+	found[0].Action = "kept"
+	var b bytes.Buffer
+	reportHallucinations(&b, found)
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if want := "  Hallucinations: 12 found (11 dropped, 1 kept)"; lines[0] != want {
+		t.Errorf("summary %q; want %q", lines[0], want)
+	}
+	if want := `    [00:00:00.000 --> 00:00:01.000] boilerplate, kept: "Thanks for watching!"`; lines[1] != want {
+		t.Errorf("first line %q; want %q", lines[1], want)
+	}
+	if want := "    ... and 2 more"; len(lines) != 12 || lines[11] != want {
+		t.Errorf("got %d lines ending %q; want 12 ending %q", len(lines), lines[len(lines)-1], want)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Language describes one language whisper can transcribe, with its codes in
+// each of the conventions subline has to deal with: whisper's own codes,
+// ISO 639-1 (two letters), ISO 639-2/B and 639-2/T (three letters, as used
+// in container metadata) and BCP-47 tags.
+type Language struct {
+	Whisper string // code whisper.cpp expects (mostly ISO 639-1)
+	ISO1    string // ISO 639-1, empty if the language has none
+	ISO2B   string // ISO 639-2 bibliographic, e.g. "ger"
+	ISO2T   string // ISO 639-2 terminology, e.g. "deu"
+	BCP47   string // shortest BCP-47 primary language subtag
+	Name    string // English name
+}
+
+// languages lists every language supported by whisper, in whisper's order.
+var languages = []Language{
+	{"en", "en", "eng", "eng", "en", "English"},
+	{"zh", "zh", "chi", "zho", "zh", "Chinese"},
+	{"de", "de", "ger", "deu", "de", "German"},
+	{"es", "es", "spa", "spa", "es", "Spanish"},
+	{"ru", "ru", "rus", "rus", "ru", "Russian"},
+	{"ko", "ko", "kor", "kor", "ko", "Korean"},
+	{"fr", "fr", "fre", "fra", "fr", "French"},
+	{"ja", "ja", "jpn", "jpn", "ja", "Japanese"},
+	{"pt", "pt", "por", "por", "pt", "Portuguese"},
+	{"tr", "tr", "tur", "tur", "tr", "Turkish"},
+	{"pl", "pl", "pol", "pol", "pl", "Polish"},
+	{"ca", "ca", "cat", "cat", "ca", "Catalan"},
+	{"nl", "nl", "dut", "nld", "nl", "Dutch"},
+	{"ar", "ar", "ara", "ara", "ar", "Arabic"},
+	{"sv", "sv", "swe", "swe", "sv", "Swedish"},
+	{"it", "it", "ita", "ita", "it", "Italian"},
+	{"id", "id", "ind", "ind", "id", "Indonesian"},
+	{"hi", "hi", "hin", "hin", "hi", "Hindi"},
+	{"fi", "fi", "fin", "fin", "fi", "Finnish"},
+	{"vi", "vi", "vie", "vie", "vi", "Vietnamese"},
+	{"he", "he", "heb", "heb", "he", "Hebrew"},
+	{"uk", "uk", "ukr", "ukr", "uk", "Ukrainian"},
+	{"el", "el", "gre", "ell", "el", "Greek"},
+	{"ms", "ms", "may", "msa", "ms", "Malay"},
+	{"cs", "cs", "cze", "ces", "cs", "Czech"},
+	{"ro", "ro", "rum", "ron", "ro", "Romanian"},
+	{"da", "da", "dan", "dan", "da", "Danish"},
+	{"hu", "hu", "hun", "hun", "hu", "Hungarian"},
+	{"ta", "ta", "tam", "tam", "ta", "Tamil"},
+	{"no", "no", "nor", "nor", "no", "Norwegian"},
+	{"th", "th", "tha", "tha", "th", "Thai"},
+	{"ur", "ur", "urd", "urd", "ur", "Urdu"},
+	{"hr", "hr", "hrv", "hrv", "hr", "Croatian"},
+	{"bg", "bg", "bul", "bul", "bg", "Bulgarian"},
+	{"lt", "lt", "lit", "lit", "lt", "Lithuanian"},
+	{"la", "la", "lat", "lat", "la", "Latin"},
+	{"mi", "mi", "mao", "mri", "mi", "Maori"},
+	{"ml", "ml", "mal", "mal", "ml", "Malayalam"},
+	{"cy", "cy", "wel", "cym", "cy", "Welsh"},
+	{"sk", "sk", "slo", "slk", "sk", "Slovak"},
+	{"te", "te", "tel", "tel", "te", "Telugu"},
+	{"fa", "fa", "per", "fas", "fa", "Persian"},
+	{"lv", "lv", "lav", "lav", "lv", "Latvian"},
+	{"bn", "bn", "ben", "ben", "bn", "Bengali"},
+	{"sr", "sr", "srp", "srp", "sr", "Serbian"},
+	{"az", "az", "aze", "aze", "az", "Azerbaijani"},
+	{"sl", "sl", "slv", "slv", "sl", "Slovenian"},
+	{"kn", "kn", "kan", "kan", "kn", "Kannada"},
+	{"et", "et", "est", "est", "et", "Estonian"},
+	{"mk", "mk", "mac", "mkd", "mk", "Macedonian"},
+	{"br", "br", "bre", "bre", "br", "Breton"},
+	{"eu", "eu", "baq", "eus", "eu", "Basque"},
+	{"is", "is", "ice", "isl", "is", "Icelandic"},
+	{"hy", "hy", "arm", "hye", "hy", "Armenian"},
+	{"ne", "ne", "nep", "nep", "ne", "Nepali"},
+	{"mn", "mn", "mon", "mon", "mn", "Mongolian"},
+	{"bs", "bs", "bos", "bos", "bs", "Bosnian"},
+	{"kk", "kk", "kaz", "kaz", "kk", "Kazakh"},
+	{"sq", "sq", "alb", "sqi", "sq", "Albanian"},
+	{"sw", "sw", "swa", "swa", "sw", "Swahili"},
+	{"gl", "gl", "glg", "glg", "gl", "Galician"},
+	{"mr", "mr", "mar", "mar", "mr", "Marathi"},
+	{"pa", "pa", "pan", "pan", "pa", "Punjabi"},
+	{"si", "si", "sin", "sin", "si", "Sinhala"},
+	{"km", "km", "khm", "khm", "km", "Khmer"},
+	{"sn", "sn", "sna", "sna", "sn", "Shona"},
+	{"yo", "yo", "yor", "yor", "yo", "Yoruba"},
+	{"so", "so", "som", "som", "so", "Somali"},
+	{"af", "af", "afr", "afr", "af", "Afrikaans"},
+	{"oc", "oc", "oci", "oci", "oc", "Occitan"},
+	{"ka", "ka", "geo", "kat", "ka", "Georgian"},
+	{"be", "be", "bel", "bel", "be", "Belarusian"},
+	{"tg", "tg", "tgk", "tgk", "tg", "Tajik"},
+	{"sd", "sd", "snd", "snd", "sd", "Sindhi"},
+	{"gu", "gu", "guj", "guj", "gu", "Gujarati"},
+	{"am", "am", "amh", "amh", "am", "Amharic"},
+	{"yi", "yi", "yid", "yid", "yi", "Yiddish"},
+	{"lo", "lo", "lao", "lao", "lo", "Lao"},
+	{"uz", "uz", "uzb", "uzb", "uz", "Uzbek"},
+	{"fo", "fo", "fao", "fao", "fo", "Faroese"},
+	{"ht", "ht", "hat", "hat", "ht", "Haitian Creole"},
+	{"ps", "ps", "pus", "pus", "ps", "Pashto"},
+	{"tk", "tk", "tuk", "tuk", "tk", "Turkmen"},
+	{"nn", "nn", "nno", "nno", "nn", "Nynorsk"},
+	{"mt", "mt", "mlt", "mlt", "mt", "Maltese"},
+	{"sa", "sa", "san", "san", "sa", "Sanskrit"},
+	{"lb", "lb", "ltz", "ltz", "lb", "Luxembourgish"},
+	{"my", "my", "bur", "mya", "my", "Myanmar"},
+	{"bo", "bo", "tib", "bod", "bo", "Tibetan"},
+	{"tl", "tl", "tgl", "tgl", "tl", "Tagalog"},
+	{"mg", "mg", "mlg", "mlg", "mg", "Malagasy"},
+	{"as", "as", "asm", "asm", "as", "Assamese"},
+	{"tt", "tt", "tat", "tat", "tt", "Tatar"},
+	{"haw", "", "haw", "haw", "haw", "Hawaiian"},
+	{"ln", "ln", "lin", "lin", "ln", "Lingala"},
+	{"ha", "ha", "hau", "hau", "ha", "Hausa"},
+	{"ba", "ba", "bak", "bak", "ba", "Bashkir"},
+	{"jw", "jv", "jav", "jav", "jv", "Javanese"},
+	{"su", "su", "sun", "sun", "su", "Sundanese"},
+	{"yue", "", "yue", "yue", "yue", "Cantonese"},
+}
+
+// languageIndex maps every known code and lower-cased name to its entry.
+var languageIndex = func() map[string]Language {
+	idx := map[string]Language{}
+	for _, l := range languages {
+		for _, key := range []string{l.Whisper, l.ISO1, l.ISO2B, l.ISO2T, l.BCP47, strings.ToLower(l.Name)} {
+			if key == "" {
+				continue
+			}
+			if _, dup := idx[key]; !dup {
+				idx[key] = l
+			}
+		}
+	}
+	return idx
+}()
+
+// LookupLanguage finds a language by any of its codes or its English name,
+// case-insensitively. BCP-47 tags with region or script subtags ("pt-BR",
+// "zh_Hans") are matched on their primary subtag.
+func LookupLanguage(code string) (Language, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if l, ok := languageIndex[code]; ok {
+		return l, true
+	}
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		if l, ok := languageIndex[code[:i]]; ok {
+			return l, true
+		}
+	}
+	return Language{}, false
+}
+
+// Language code styles accepted by --lang-codes.
+const (
+	LangStyleISO1  = "639-1"
+	LangStyleISO2B = "639-2b"
+	LangStyleISO2T = "639-2t"
+	LangStyleBCP47 = "bcp47"
+)
+
+// ValidLangStyle reports whether style is one of the LangStyle constants.
+func ValidLangStyle(style string) bool {
+	switch style {
+	case LangStyleISO1, LangStyleISO2B, LangStyleISO2T, LangStyleBCP47:
+		return true
+	}
+	return false
+}
+
+// Code returns the language's code in the given style. Languages without
+// an ISO 639-1 code fall back to their BCP-47 tag.
+func (l Language) Code(style string) string {
+	switch style {
+	case LangStyleISO1:
+		if l.ISO1 != "" {
+			return l.ISO1
+		}
+		return l.BCP47
+	case LangStyleISO2B:
+		return l.ISO2B
+	case LangStyleISO2T:
+		return l.ISO2T
+	default:
+		return l.BCP47
+	}
+}
+
+// NormalizeLanguage converts any known language code to the given style.
+// Empty, "und" and unrecognised codes are returned as "und".
+func NormalizeLanguage(code, style string) string {
+	l, ok := LookupLanguage(code)
+	if !ok {
+		return "und"
+	}
+	return l.Code(style)
+}
+
+// WhisperLanguage converts a user- or container-supplied language code to
+// the code whisper expects. An empty code means auto-detect and is returned
+// unchanged; an unrecognised code is an error.
+func WhisperLanguage(code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	l, ok := LookupLanguage(code)
+	if !ok {
+		return "", fmt.Errorf("unknown language %q", code)
+	}
+	return l.Whisper, nil
+}
+
+// LanguageLabel returns a human-readable label such as "Russian (ru)" for
+// log output, or the code itself if it is not recognised.
+func LanguageLabel(code string) string {
+	l, ok := LookupLanguage(code)
+	if !ok {
+		return code
+	}
+	return l.Name + " (" + l.BCP47 + ")"
+}
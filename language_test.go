@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestLookupLanguage_AllCodeStyles(t *testing.T) {
+	for _, code := range []string{"de", "ger", "deu", "DEU", "German", "de-AT", "de_CH"} {
+		l, ok := LookupLanguage(code)
+		if !ok {
+			t.Errorf("LookupLanguage(%q) not found", code)
+			continue
+		}
+		if l.Whisper != "de" {
+			t.Errorf("LookupLanguage(%q).Whisper = %q; want %q", code, l.Whisper, "de")
+		}
+	}
+}
+
+func TestLookupLanguage_Unknown(t *testing.T) {
+	for _, code := range []string{"", "und", "xx", "zzz"} {
+		if _, ok := LookupLanguage(code); ok {
+			t.Errorf("LookupLanguage(%q) should not be found", code)
+		}
+	}
+}
+
+func TestLanguageCode_Styles(t *testing.T) {
+	l, _ := LookupLanguage("fra")
+	cases := map[string]string{
+		LangStyleISO1:  "fr",
+		LangStyleISO2B: "fre",
+		LangStyleISO2T: "fra",
+		LangStyleBCP47: "fr",
+	}
+	for style, want := range cases {
+		if got := l.Code(style); got != want {
+			t.Errorf("Code(%q) = %q; want %q", style, got, want)
+		}
+	}
+}
+
+func TestLanguageCode_NoISO1FallsBackToBCP47(t *testing.T) {
+	l, _ := LookupLanguage("haw")
+	if got := l.Code(LangStyleISO1); got != "haw" {
+		t.Errorf("Code(639-1) for Hawaiian = %q; want %q", got, "haw")
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	cases := []struct{ code, style, want string }{
+		{"rus", LangStyleISO1, "ru"},
+		{"ru", LangStyleISO2B, "rus"},
+		{"chi", LangStyleISO2T, "zho"},
+		{"jw", LangStyleBCP47, "jv"},
+		{"und", LangStyleISO1, "und"},
+		{"", LangStyleISO2B, "und"},
+	}
+	for _, c := range cases {
+		if got := NormalizeLanguage(c.code, c.style); got != c.want {
+			t.Errorf("NormalizeLanguage(%q, %q) = %q; want %q", c.code, c.style, got, c.want)
+		}
+	}
+}
+
+func TestWhisperLanguage(t *testing.T) {
+	if got, err := WhisperLanguage("eng"); err != nil || got != "en" {
+		t.Errorf("WhisperLanguage(eng) = %q, %v; want en", got, err)
+	}
+	if got, err := WhisperLanguage("jav"); err != nil || got != "jw" {
+		t.Errorf("WhisperLanguage(jav) = %q, %v; want jw", got, err)
+	}
+	if got, err := WhisperLanguage(""); err != nil || got != "" {
+		t.Errorf("WhisperLanguage(\"\") = %q, %v; want empty", got, err)
+	}
+	if _, err := WhisperLanguage("klingon"); err == nil {
+		t.Error("WhisperLanguage(klingon) should return an error")
+	}
+}
+
+func TestValidLangStyle(t *testing.T) {
+	if !ValidLangStyle(LangStyleBCP47) {
+		t.Error("bcp47 should be a valid style")
+	}
+	if ValidLangStyle("iso") {
+		t.Error("iso should not be a valid style")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockPollInterval is how often a process waiting for another one's
+// download checks on it. Tests shorten it.
+var lockPollInterval = 500 * time.Millisecond
+
+// downloadLock keeps other subline processes from downloading the same
+// model file at the same time. It is an advisory lock on dest+".lock",
+// which also holds the size of the file once the download knows it, so
+// waiting processes can show progress. The lock file is left in place:
+// removing it could let two processes lock different files.
+type downloadLock struct {
+	f *os.File
+}
+
+// lockDownload takes the download lock of the model file dest. If another
+// process holds it, it waits for that download to finish, printing its
+// progress to stderr, and reports waited = true; the caller should then
+// check whether dest is there before downloading it again.
+func lockDownload(dest, name string) (l *downloadLock, waited bool, err error) {
+	f, err := os.OpenFile(dest+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, fmt.Errorf("creating lock file: %w", err)
+	}
+	ok, err := tryLockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("locking %s: %w", f.Name(), err)
+	}
+	if ok {
+		return &downloadLock{f: f}, false, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Another subline process is downloading model '%s', waiting for it...\n", name)
+	percent := -1
+	for {
+		time.Sleep(lockPollInterval)
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, true, fmt.Errorf("locking %s: %w", f.Name(), err)
+		}
+		if ok {
+			return &downloadLock{f: f}, true, nil
+		}
+		if total := lockedTotal(f.Name()); total > 0 {
+			p := int(partialSize(dest) * 100 / total)
+			if p/10 > percent/10 {
+				fmt.Fprintf(os.Stderr, "  %d%%\n", p)
+				percent = p
+			}
+		}
+	}
+}
+
+// setTotal records the size of the file being downloaded in the lock file.
+func (l *downloadLock) setTotal(total int64) {
+	if l == nil {
+		return
+	}
+	if err := l.f.Truncate(0); err == nil {
+		l.f.WriteAt([]byte(strconv.FormatInt(total, 10)+"\n"), 0)
+	}
+}
+
+// unlock releases the lock.
+func (l *downloadLock) unlock() {
+	unlockFile(l.f)
+	l.f.Close()
+}
+
+// lockedTotal returns the file size recorded in a lock file, or 0.
+func lockedTotal(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+// partialDownloads returns the partial downloads of the model file dest:
+// dest.download-<random>.tmp, and dest.tmp as left by earlier versions.
+func partialDownloads(dest string) []string {
+	entries, _ := os.ReadDir(filepath.Dir(dest))
+	var paths []string
+	for _, e := range entries {
+		if model, ok := isPartialDownload(e.Name()); ok && model == filepath.Base(dest) {
+			paths = append(paths, filepath.Join(filepath.Dir(dest), e.Name()))
+		}
+	}
+	return paths
+}
+
+// partialSize returns the size of the largest partial download of dest.
+func partialSize(dest string) int64 {
+	var size int64
+	for _, p := range partialDownloads(dest) {
+		if info, err := os.Stat(p); err == nil {
+			size = max(size, info.Size())
+		}
+	}
+	return size
+}
+
+// isPartialDownload reports whether the cache file name is a partial
+// download, and of which model file.
+func isPartialDownload(name string) (model string, ok bool) {
+	base, ok := strings.CutSuffix(name, ".tmp")
+	if !ok {
+		return "", false
+	}
+	if i := strings.LastIndex(base, ".download-"); i > 0 {
+		base = base[:i]
+	}
+	return base, true
+}
+
+// downloadInProgress reports whether a process holds the download lock of
+// the model file dest.
+func downloadInProgress(dest string) bool {
+	f, err := os.Open(dest + ".lock")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	ok, err := tryLockFile(f)
+	if err != nil {
+		return false
+	}
+	if ok {
+		unlockFile(f)
+	}
+	return !ok
+}
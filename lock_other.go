@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// tryLockFile is not implemented outside Unix; concurrent downloads of the
+// same model still each use their own temporary file.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile releases the lock on f.
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEnsureModel_ConcurrentProcessesDownloadOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("download locks are not implemented on Windows")
+	}
+	data := testModelData(50000)
+	started, release := make(chan struct{}), make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	origCache, origMirrors, origPoll := cacheDirOverride, modelMirrors, lockPollInterval
+	cacheDirOverride = t.TempDir()
+	modelMirrors = []modelMirror{{URL: srv.URL + "/"}}
+	lockPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() {
+		cacheDirOverride, modelMirrors, lockPollInterval = origCache, origMirrors, origPoll
+	})
+
+	// Each lock file is opened separately, so the two calls contend for
+	// it just like two processes do.
+	var wg sync.WaitGroup
+	paths := make([]string, 2)
+	errs := make([]error, 2)
+	ensure := func(i int) {
+		defer wg.Done()
+		paths[i], errs[i] = EnsureModel("tiny", ModelOptions{})
+	}
+	wg.Add(2)
+	go ensure(0)
+	<-started
+	go ensure(1)
+	time.Sleep(20 * lockPollInterval)
+	close(release)
+	wg.Wait()
+
+	for i := range errs {
+		if errs[i] != nil {
+			t.Fatalf("EnsureModel #%d: %v", i, errs[i])
+		}
+	}
+	if paths[0] != paths[1] {
+		t.Errorf("paths %q and %q differ", paths[0], paths[1])
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("model requested %d times; want once", n)
+	}
+	if got, _ := os.ReadFile(paths[0]); len(got) != len(data) {
+		t.Errorf("model file has %d bytes; want %d", len(got), len(data))
+	}
+	if p := partialDownloads(paths[0]); len(p) > 0 {
+		t.Errorf("temporary files left behind: %q", p)
+	}
+}
+
+func TestClaimPartial_ResumesLargestPartial(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	os.WriteFile(dest+".tmp", []byte("old"), 0644)
+	os.WriteFile(dest+".download-123.tmp", []byte("longest"), 0644)
+	os.WriteFile(dest+".download-456.tmp", []byte("mid"), 0644)
+
+	f, err := claimPartial(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, _ := os.ReadFile(f.Name()); string(got) != "longest" {
+		t.Errorf("claimed partial holds %q; want the longest one", got)
+	}
+	if p := partialDownloads(dest); len(p) != 1 || p[0] != f.Name() {
+		t.Errorf("partial downloads after claiming: %q; want only %s", p, f.Name())
+	}
+}
+
+func TestModelsPrune_KeepsRunningDownload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("download locks are not implemented on Windows")
+	}
+	dir := modelsCache(t, map[string]string{
+		"ggml-small.bin.download-1.tmp": "part",
+	})
+	lock, _, err := lockDownload(filepath.Join(dir, "ggml-small.bin"), "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := runModelsTest("prune"); code != 0 {
+		t.Fatalf("models prune exited %d: %s", code, errOut)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ggml-small.bin.download-1.tmp")); err != nil {
+		t.Error("prune removed the partial file of a running download")
+	}
+
+	if code, _, errOut := runModelsTest("remove", "small"); code != 1 || !strings.Contains(errOut, "being downloaded") {
+		t.Errorf("removing a model being downloaded = %d, %q; want an error", code, errOut)
+	}
+
+	lock.unlock()
+	runModelsTest("prune")
+	if _, err := os.Stat(filepath.Join(dir, "ggml-small.bin.download-1.tmp")); err == nil {
+		t.Error("prune kept the partial file once the download stopped")
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -15,6 +17,27 @@ import (
 var Version = "dev"
 
 func main() {
+	os.Exit(run())
+}
+
+// run is the body of main. It returns the exit status instead of calling
+// os.Exit, so deferred cleanup always runs: 2 for invalid usage, 1 for
+// other errors.
+func run() int {
+	// Custom models from the registry file extend the built-in ones, and
+	// mirrors may replace where models are downloaded from.
+	for _, load := range []func() error{LoadModelRegistry, LoadModelMirrors} {
+		if err := load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	// "subline models <command>" manages the model cache.
+	if len(os.Args) > 1 && os.Args[1] == "models" {
+		return runModels(os.Args[2:], os.Stdout, os.Stderr)
+	}
+
 	// Banner.
 	fmt.Printf("\n\033[1m"+
 		"░▄▀▀░█▒█░██▄░█▒░░█░█▄░█▒██▀\n"+
@@ -22,55 +45,358 @@ func main() {
 		"\033[0m\n"+
 		"Subline %s - AI subtitles made easy\n\n", Version)
 
-	// Parse flags (with shorthands).
-	var language, model, format, outputDir string
-	var audioTrack int
-	var skipExisting, verbose bool
+	// "subline watch <dir...>" keeps running and transcribes new files.
+	args := os.Args[1:]
+	watchMode := len(args) > 0 && args[0] == "watch"
+	if watchMode {
+		args = args[1:]
+	}
 
-	flag.StringVar(&language, "language", "", "Language code (auto-detect if omitted)")
+	// Parse flags (with shorthands).
+	var language, model, cascade, format, outputDir, langCodes, outputTemplate, overwrite, fromFile string
+	var audioTrack, jobs, prefetch int
+	var skipExisting, verbose, codeSwitching, chunked, live, forced, sdh, trustTags bool
+	var modelOpts ModelOptions
+	var discoverOpts DiscoverOptions
+	var settle, pollInterval, timeout, deadline time.Duration
+	var maxRTF, targetRTF, cascadeThreshold float64
+	var hallucinations, review string
+	var reviewPercent float64
+
+	flag.StringVar(&language, "language", "", "Language code, ISO 639-1/639-2 or BCP-47 (auto-detect if omitted)")
 	flag.StringVar(&language, "l", "", "Language code (shorthand)")
-	flag.StringVar(&model, "model", "turbo", "Whisper model (tiny/base/small/medium/turbo/large)")
+	flag.BoolVar(&trustTags, "trust-track-language", false, "Transcribe in the language a track is tagged with instead of detecting it")
+	flag.StringVar(&model, "model", "turbo", "Whisper model (auto, tiny/base/small/medium/turbo/large, a registered name, or a .bin path, URL or HuggingFace repo/file)")
 	flag.StringVar(&model, "m", "turbo", "Whisper model (shorthand)")
+	flag.Float64Var(&targetRTF, "target-rtf", 0, "With --model auto: aim to transcribe within this multiple of the audio duration (default 0.25)")
+	flag.DurationVar(&deadline, "deadline", 0, "With --model auto: aim to finish the whole run within this time, e.g. 8h")
+	flag.BoolVar(&modelOpts.Verify, "verify-model", false, "Check the model file's SHA-256 checksum before loading it")
+	flag.BoolVar(&modelOpts.Offline, "offline", false, "Never download models; fail if the model is not cached")
+	addDownloadFlags(flag.CommandLine, &modelOpts)
 	flag.IntVar(&audioTrack, "audio-track", -1, "Audio stream index (-1 = auto-detect)")
 	flag.IntVar(&audioTrack, "a", -1, "Audio stream index (shorthand)")
-	flag.StringVar(&format, "format", "srt", "Output format: srt or vtt")
+	flag.StringVar(&format, "format", "srt", "Output format: srt, vtt, ttml or json")
 	flag.StringVar(&format, "f", "srt", "Output format (shorthand)")
 	flag.StringVar(&outputDir, "output-dir", "", "Directory to write subtitle files (default: next to source)")
 	flag.StringVar(&outputDir, "o", "", "Output directory (shorthand)")
 	flag.BoolVar(&skipExisting, "skip-existing", false, "Skip files that already have a subtitle file")
 	flag.BoolVar(&skipExisting, "s", false, "Skip existing (shorthand)")
+	flag.StringVar(&overwrite, "overwrite", OverwriteAlways, "Existing subtitle files: always, never or rename")
+	flag.StringVar(&fromFile, "from-file", "", "Read paths from a list file (- for stdin)")
+	flag.BoolVar(&discoverOpts.Recursive, "recursive", false, "Search directories recursively")
+	flag.BoolVar(&discoverOpts.Recursive, "r", false, "Recursive (shorthand)")
+	flag.IntVar(&discoverOpts.MaxDepth, "max-depth", 0, "Directory levels to search with --recursive (0 = unlimited)")
+	flag.Var((*stringList)(&discoverOpts.Include), "include", "Only process files matching this glob (repeatable)")
+	flag.Var((*stringList)(&discoverOpts.Exclude), "exclude", "Skip files and directories matching this glob (repeatable)")
+	flag.BoolVar(&discoverOpts.Hidden, "hidden", false, "Include hidden files and directories when recursing")
+	flag.BoolVar(&discoverOpts.FollowSymlinks, "follow-symlinks", false, "Follow symbolic links inside directories")
+	flag.StringVar(&outputTemplate, "output-template", "", "Output file name template or preset (plex/jellyfin/kodi)")
+	flag.BoolVar(&forced, "forced", false, "Mark subtitles as forced in file names")
+	flag.BoolVar(&sdh, "sdh", false, "Mark subtitles as SDH in file names")
+	flag.StringVar(&langCodes, "lang-codes", LangStyleISO2B, "Language code style in file names: 639-1, 639-2b, 639-2t or bcp47")
+	flag.IntVar(&jobs, "jobs", 1, "Files to transcribe in parallel; CPU threads are split between them")
+	flag.IntVar(&jobs, "j", 1, "Parallel jobs (shorthand)")
+	flag.BoolVar(&chunked, "chunked", false, "Split each file into chunks transcribed by all --jobs at once")
+	flag.IntVar(&prefetch, "prefetch", 0, "Audio tracks decoded ahead while transcribing (0 = off)")
+	flag.DurationVar(&timeout, "timeout", 0, "Give up on a file whose transcription takes longer than this (0 = no limit)")
+	flag.Float64Var(&maxRTF, "max-rtf", 0, "Give up on a file whose transcription takes longer than this multiple of its duration (0 = no limit)")
+	flag.BoolVar(&codeSwitching, "code-switching", false, "Detect language per region for audio that switches languages")
+	flag.StringVar(&cascade, "cascade", "", "Larger model to transcribe low-confidence regions again with, e.g. large")
+	flag.Float64Var(&cascadeThreshold, "cascade-threshold", defaultCascadeThreshold, "Segment confidence (0-1) below which --cascade transcribes it again")
+	flag.StringVar(&hallucinations, "hallucinations", HallucinationsReport, "Repetition loops and other hallucinations: report, retry, drop or off")
+	flag.StringVar(&review, "review", "", "Write a report of the lowest-confidence cues next to each subtitle file: txt or html")
+	flag.Float64Var(&reviewPercent, "review-percent", defaultReviewPercent, "Share of cues (in percent) the --review report lists")
+	flag.DurationVar(&settle, "settle", 10*time.Second, "Watch mode: how long a new file must stay unchanged before it is processed")
+	flag.DurationVar(&pollInterval, "poll-interval", 5*time.Second, "Watch mode: how often directories are rescanned")
+	flag.BoolVar(&live, "live", false, "Print segments as they are transcribed")
 	flag.BoolVar(&verbose, "verbose", false, "Show detailed model loading and engine output")
 	flag.BoolVar(&verbose, "v", false, "Verbose (shorthand)")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: subline [options] <path...>\n\nOptions:\n")
-		fmt.Fprintf(os.Stderr, "  -l, --language string    Language code (auto-detect if omitted)\n")
-		fmt.Fprintf(os.Stderr, "  -m, --model string       Whisper model (tiny/base/small/medium/turbo/large) (default \"turbo\")\n")
+		fmt.Fprintf(os.Stderr, "Usage: subline [options] <path...>\n")
+		fmt.Fprintf(os.Stderr, "       find ... -print0 | subline [options] -\n")
+		fmt.Fprintf(os.Stderr, "       subline watch [options] <dir...>\n")
+		fmt.Fprintf(os.Stderr, "       subline models list|download|remove|verify|path|prune\n\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  -l, --language string    Language code, ISO 639-1/639-2 or BCP-47 (auto-detect if omitted)\n")
+		fmt.Fprintf(os.Stderr, "      --trust-track-language\n")
+		fmt.Fprintf(os.Stderr, "                           Transcribe in the language a track is tagged with instead of detecting it\n")
+		fmt.Fprintf(os.Stderr, "  -m, --model string       Whisper model (tiny/base/small/medium/turbo/large, .en for English only) (default \"turbo\"),\n")
+		fmt.Fprintf(os.Stderr, "                           auto to pick one for the audio and machine, a name from the model registry,\n")
+		fmt.Fprintf(os.Stderr, "                           or a .bin path, URL or HuggingFace repo/file\n")
+		fmt.Fprintf(os.Stderr, "      --target-rtf float   With --model auto: aim to transcribe within this multiple of the audio duration (default 0.25)\n")
+		fmt.Fprintf(os.Stderr, "      --deadline duration  With --model auto: aim to finish the whole run within this time, e.g. 8h\n")
+		fmt.Fprintf(os.Stderr, "      --verify-model       Check the model file's SHA-256 checksum before loading it, re-download if corrupted\n")
+		fmt.Fprintf(os.Stderr, "      --offline            Never download models; fail if the model is not cached\n")
 		fmt.Fprintf(os.Stderr, "  -a, --audio-track int    Audio stream index (-1 = auto-detect) (default -1)\n")
-		fmt.Fprintf(os.Stderr, "  -f, --format string      Output format: srt or vtt (default \"srt\")\n")
+		fmt.Fprintf(os.Stderr, "  -f, --format string      Output format: srt, vtt, ttml or json (default \"srt\")\n")
 		fmt.Fprintf(os.Stderr, "  -o, --output-dir string  Directory to write subtitle files (default: next to source)\n")
-		fmt.Fprintf(os.Stderr, "  -s, --skip-existing      Skip files that already have a subtitle file\n")
+		fmt.Fprintf(os.Stderr, "      --from-file path     Read paths from a list file, one per line or NUL-separated (- for stdin)\n")
+		fmt.Fprintf(os.Stderr, "  -r, --recursive          Search directories recursively (mirrored under --output-dir)\n")
+		fmt.Fprintf(os.Stderr, "      --max-depth int      Directory levels to search with --recursive (0 = unlimited)\n")
+		fmt.Fprintf(os.Stderr, "      --include glob       Only process files matching this glob (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "      --exclude glob       Skip files and directories matching this glob (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "      --hidden             Include hidden files and directories when recursing\n")
+		fmt.Fprintf(os.Stderr, "      --follow-symlinks    Follow symbolic links inside directories\n")
+		fmt.Fprintf(os.Stderr, "  -s, --skip-existing      Skip files that already have a subtitle file (same as --overwrite=never)\n")
+		fmt.Fprintf(os.Stderr, "      --overwrite string   Existing subtitle files: always, never or rename (default \"always\")\n")
+		fmt.Fprintf(os.Stderr, "      --output-template string\n")
+		fmt.Fprintf(os.Stderr, "                           Output file name template or preset (plex/jellyfin/kodi)\n")
+		fmt.Fprintf(os.Stderr, "                           Tokens: {name} {lang} {lang2} {lang3} {track} {model} {format} {forced} {sdh}\n")
+		fmt.Fprintf(os.Stderr, "      --forced             Mark subtitles as forced in file names\n")
+		fmt.Fprintf(os.Stderr, "      --sdh                Mark subtitles as SDH in file names\n")
+		fmt.Fprintf(os.Stderr, "      --lang-codes string  Language code style in file names: 639-1, 639-2b, 639-2t or bcp47 (default \"639-2b\")\n")
+		fmt.Fprintf(os.Stderr, "  -j, --jobs int           Files to transcribe in parallel; CPU threads are split between them (default 1)\n")
+		fmt.Fprintf(os.Stderr, "      --chunked            Split each file into chunks transcribed by all --jobs at once\n")
+		fmt.Fprintf(os.Stderr, "      --prefetch int       Audio tracks decoded ahead while transcribing, 0 = off (default 0)\n")
+		fmt.Fprintf(os.Stderr, "      --timeout duration   Give up on a file whose transcription takes longer, e.g. 2h (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "      --max-rtf float      Give up on a file whose transcription takes longer than this multiple of its duration\n")
+		fmt.Fprintf(os.Stderr, "      --code-switching     Detect language per region for audio that switches languages\n")
+		fmt.Fprintf(os.Stderr, "      --cascade string     Larger model to transcribe low-confidence or repetitive regions again with, e.g. large\n")
+		fmt.Fprintf(os.Stderr, "      --cascade-threshold float\n")
+		fmt.Fprintf(os.Stderr, "                           Segment confidence (0-1) below which --cascade transcribes it again (default %g)\n", defaultCascadeThreshold)
+		fmt.Fprintf(os.Stderr, "      --hallucinations string\n")
+		fmt.Fprintf(os.Stderr, "                           Repetition loops and other hallucinations: report (list them), retry (decode\n")
+		fmt.Fprintf(os.Stderr, "                           again, drop if still suspect), drop or off (default \"report\")\n")
+		fmt.Fprintf(os.Stderr, "      --review string      Write a report of the lowest-confidence cues next to each subtitle file: txt or html\n")
+		fmt.Fprintf(os.Stderr, "      --review-percent float\n")
+		fmt.Fprintf(os.Stderr, "                           Share of cues (in percent) the --review report lists (default %g)\n", float64(defaultReviewPercent))
+		fmt.Fprintf(os.Stderr, "      --live               Print segments as they are transcribed\n")
 		fmt.Fprintf(os.Stderr, "  -v, --verbose            Show detailed model loading and engine output\n")
+		printDownloadUsage(os.Stderr)
+		fmt.Fprintf(os.Stderr, "\nWatch mode:\n")
+		fmt.Fprintf(os.Stderr, "      --settle duration    How long a new file must stay unchanged before it is processed (default 10s)\n")
+		fmt.Fprintf(os.Stderr, "      --poll-interval duration\n")
+		fmt.Fprintf(os.Stderr, "                           How often directories are rescanned (default 5s)\n")
 		fmt.Fprintln(os.Stderr)
 	}
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+
+	if format != "srt" && format != "vtt" && format != "ttml" && format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: --format must be 'srt', 'vtt', 'ttml' or 'json'\n")
+		return 2
+	}
+	if !ValidOverwritePolicy(overwrite) {
+		fmt.Fprintf(os.Stderr, "Error: --overwrite must be 'always', 'never' or 'rename'\n")
+		return 2
+	}
+	if skipExisting {
+		overwrite = OverwriteNever
+	}
+	if !ValidLangStyle(langCodes) {
+		fmt.Fprintf(os.Stderr, "Error: --lang-codes must be '639-1', '639-2b', '639-2t' or 'bcp47'\n")
+		return 2
+	}
+	for _, pat := range append(discoverOpts.Include, discoverOpts.Exclude...) {
+		if _, err := filepath.Match(pat, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid glob %q: %v\n", pat, err)
+			return 2
+		}
+	}
+	outputTemplate, err := ResolveOutputTemplate(outputTemplate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	whisperLang, err := WhisperLanguage(language)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if codeSwitching && language != "" {
+		fmt.Fprintf(os.Stderr, "Error: --code-switching cannot be combined with --language\n")
+		return 2
+	}
+	if codeSwitching && format == "srt" {
+		fmt.Fprintf(os.Stderr, "Warning: SRT cannot mark the language of each cue; use --format vtt, ttml or json to keep it\n")
+	}
+	if jobs < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --jobs must be at least 1\n")
+		return 2
+	}
+	if chunked && jobs < 2 {
+		fmt.Fprintf(os.Stderr, "Error: --chunked needs --jobs 2 or more\n")
+		return 2
+	}
+	if chunked && codeSwitching {
+		fmt.Fprintf(os.Stderr, "Error: --chunked cannot be combined with --code-switching\n")
+		return 2
+	}
+	if live && (jobs > 1 || codeSwitching) {
+		fmt.Fprintf(os.Stderr, "Error: --live cannot be combined with --jobs or --code-switching\n")
+		return 2
+	}
+	if timeout < 0 || maxRTF < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --timeout and --max-rtf must not be negative\n")
+		return 2
+	}
+	if targetRTF < 0 || deadline < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --target-rtf and --deadline must not be negative\n")
+		return 2
+	}
+	if (targetRTF > 0 || deadline > 0) && model != autoModel {
+		fmt.Fprintf(os.Stderr, "Error: --target-rtf and --deadline only apply to --model auto\n")
+		return 2
+	}
+	if deadline > 0 && watchMode {
+		fmt.Fprintf(os.Stderr, "Error: --deadline cannot be used in watch mode; use --target-rtf\n")
+		return 2
+	}
+	for _, m := range []string{model, cascade} {
+		if strings.HasSuffix(m, ".en") && (codeSwitching || (whisperLang != "" && whisperLang != "en")) {
+			fmt.Fprintf(os.Stderr, "Error: model '%s' only transcribes English\n", m)
+			return 2
+		}
+	}
+	if cascade == autoModel || (cascade != "" && cascade == model) {
+		fmt.Fprintf(os.Stderr, "Error: --cascade must name a model other than --model\n")
+		return 2
+	}
+	if cascadeThreshold <= 0 || cascadeThreshold > 1 {
+		fmt.Fprintf(os.Stderr, "Error: --cascade-threshold must be between 0 and 1\n")
+		return 2
+	}
+	if !ValidHallucinationMode(hallucinations) {
+		fmt.Fprintf(os.Stderr, "Error: --hallucinations must be 'retry', 'drop', 'report' or 'off'\n")
+		return 2
+	}
+	if review != "" && !ValidReviewFormat(review) {
+		fmt.Fprintf(os.Stderr, "Error: --review must be 'txt' or 'html'\n")
+		return 2
+	}
+	if reviewPercent <= 0 || reviewPercent > 100 {
+		fmt.Fprintf(os.Stderr, "Error: --review-percent must be between 0 and 100\n")
+		return 2
+	}
+	if prefetch < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --prefetch must not be negative\n")
+		return 2
+	}
+	if err := checkDownloadOptions(modelOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if pollInterval <= 0 || settle < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --poll-interval must be positive and --settle must not be negative\n")
+		return 2
+	}
 
-	if format != "srt" && format != "vtt" {
-		fmt.Fprintf(os.Stderr, "Error: --format must be 'srt' or 'vtt'\n")
-		os.Exit(1)
+	cfg := Config{
+		Language:           whisperLang,
+		Model:              model,
+		Format:             format,
+		OutputDir:          outputDir,
+		OutputTemplate:     outputTemplate,
+		LangCodes:          langCodes,
+		Overwrite:          overwrite,
+		AudioTrack:         audioTrack,
+		Jobs:               jobs,
+		Prefetch:           prefetch,
+		Chunked:            chunked,
+		Timeout:            timeout,
+		MaxRTF:             maxRTF,
+		CodeSwitching:      codeSwitching,
+		TrustTrackLanguage: trustTags,
+		Cascade:            cascade,
+		CascadeThreshold:   cascadeThreshold,
+		Hallucinations:     hallucinations,
+		Review:             review,
+		ReviewPercent:      reviewPercent,
+		Forced:             forced,
+		SDH:                sdh,
+		Live:               live,
+		Verbose:            verbose,
+		Unattended:         watchMode,
 	}
 
+	// Collect input paths from arguments, "-" (stdin) and --from-file.
 	paths := flag.Args()
-	if len(paths) == 0 {
-		flag.Usage()
-		os.Exit(1)
+	var files []MediaFile
+	if watchMode {
+		if len(paths) == 0 || fromFile != "" {
+			flag.Usage()
+			return 2
+		}
+		for _, p := range paths {
+			if info, err := os.Stat(p); err != nil || !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "Error: '%s' is not a directory\n", p)
+				return 2
+			}
+		}
+	} else {
+		var entries []ListEntry
+		// readList adds the entries of a path list, reporting whether it
+		// could be read.
+		readList := func(name string) bool {
+			var r io.Reader = os.Stdin
+			if name != "-" {
+				f, err := os.Open(name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return false
+				}
+				defer f.Close()
+				r = f
+			}
+			listed, err := ReadPathList(r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading path list %s: %v\n", name, err)
+				return false
+			}
+			entries = append(entries, listed...)
+			return true
+		}
+		for _, p := range paths {
+			if p == "-" {
+				if !readList(p) {
+					return 1
+				}
+			} else {
+				entries = append(entries, ListEntry{Path: p})
+			}
+		}
+		if fromFile != "" {
+			if !readList(fromFile) {
+				return 1
+			}
+			paths = append(paths, fromFile)
+		}
+		if len(entries) == 0 && len(paths) == 0 {
+			flag.Usage()
+			return 2
+		}
+
+		// Find media files.
+		files = DiscoverListedFiles(entries, discoverOpts)
+		if len(files) == 0 {
+			fmt.Fprintf(os.Stderr, "No media files found in: %s\n", strings.Join(paths, " "))
+			return 1
+		}
 	}
 
-	// Find media files.
-	files := FindMediaFiles(paths)
-	if len(files) == 0 {
-		fmt.Fprintf(os.Stderr, "No media files found in: %s\n", strings.Join(paths, " "))
-		os.Exit(1)
+	// --model auto picks a model for this run's audio and this machine.
+	if model == autoModel {
+		in := machineInput(AutoInput{
+			English:   whisperLang == "en" && !codeSwitching,
+			TargetRTF: targetRTF,
+			Deadline:  deadline,
+		})
+		if !watchMode {
+			in.Audio, in.English = surveyFiles(files, cfg)
+		}
+		if modelOpts.Offline {
+			in.Cached = map[string]bool{}
+			for name, file := range modelFiles {
+				if _, err := os.Stat(filepath.Join(CacheDir(), file)); err == nil {
+					in.Cached[name] = true
+				}
+			}
+		}
+		if deadline > 0 && in.Audio == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: the duration of the audio is unknown, so --deadline cannot be planned for; using --target-rtf\n")
+		}
+		choice := ChooseModel(in)
+		model, cfg.Model = choice.Model, choice.Model
+		fmt.Printf("Auto model: %s\n", choice.describe(in))
 	}
 
 	// Device info.
@@ -79,18 +405,34 @@ func main() {
 		device = "Metal"
 	}
 
-	langStr := language
-	if langStr == "" {
+	langStr := LanguageLabel(whisperLang)
+	if codeSwitching {
+		langStr = "code-switching"
+	} else if whisperLang == "" {
 		langStr = "auto-detect"
 	}
-	fmt.Printf("Found %d file(s) | model=%s | language=%s | device=%s\n\n", len(files), model, langStr, device)
+	if jobs > 1 {
+		device += fmt.Sprintf(" | jobs=%d", jobs)
+		if chunked {
+			device += " (chunked)"
+		}
+	}
+	modelStr := model
+	if cascade != "" {
+		modelStr += " (cascade: " + cascade + ")"
+	}
+	if watchMode {
+		fmt.Printf("Watching %d dir(s) | model=%s | language=%s | device=%s\n\n", len(paths), modelStr, langStr, device)
+	} else {
+		fmt.Printf("Found %d file(s) | model=%s | language=%s | device=%s\n\n", len(files), modelStr, langStr, device)
+	}
 
 	// Ensure model is downloaded.
 	fmt.Fprintf(os.Stderr, "Loading model '%s'...\n", model)
-	modelPath, err := EnsureModel(model)
+	modelPath, err := EnsureModel(model, modelOpts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	// Helper to run a function with C stdout/stderr suppressed.
@@ -109,146 +451,156 @@ func main() {
 	quiet(func() { wm, err = LoadModel(modelPath) })
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
-		os.Exit(1)
+		if !modelOpts.Verify {
+			fmt.Fprintf(os.Stderr, "The model file may be corrupted; rerun with --verify-model to check it and download it again.\n")
+		}
+		return 1
 	}
 	defer func() { quiet(func() { wm.Close() }) }()
+
+	// The --cascade model transcribes doubtful regions again.
+	var cm *WhisperModel
+	if cascade != "" {
+		fmt.Fprintf(os.Stderr, "Loading cascade model '%s'...\n", cascade)
+		cascadePath, err := EnsureModel(cascade, modelOpts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		quiet(func() { cm, err = LoadModel(cascadePath) })
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading cascade model: %v\n", err)
+			return 1
+		}
+		defer func() { quiet(func() { cm.Close() }) }()
+	}
 	fmt.Println()
 
 	// Create output directory if specified.
 	if outputDir != "" {
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
-			os.Exit(1)
+			return 1
 		}
 	}
 
-	// Signal handling: clean up partial output on interrupt.
-	var currentOutput string
-	cancelSignal := SignalCleanup(&currentOutput)
-	defer cancelSignal()
-
-	// Process each file.
-	for vi, file := range files {
-		fmt.Printf("[%d/%d] %s\n", vi+1, len(files), filepath.Base(file))
-
-		// Probe audio tracks.
-		tracks, err := ProbeAudioTracks(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "  Error probing audio: %v\n", err)
-			continue
+	// One engine per job. Parallel jobs share the model weights through
+	// separate whisper states, each with its share of the CPU threads.
+	engines := []Transcriber{wm}
+	if jobs > 1 {
+		threads := runtime.NumCPU() / jobs
+		engines = nil
+		for i := 0; i < jobs; i++ {
+			var state *WhisperState
+			quiet(func() { state, err = wm.NewState(threads) })
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating whisper state: %v\n", err)
+				return 1
+			}
+			defer func() { quiet(func() { state.Close() }) }()
+			engines = append(engines, state)
 		}
+	}
 
-		// Pick audio track(s).
-		streamIndices, err := PickAudioTracks(tracks, audioTrack)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "  %v, skipping\n", err)
-			continue
+	// Likewise, each job gets its own state of the --cascade model.
+	var refiners []Transcriber
+	if cm != nil {
+		refiners = []Transcriber{cm}
+		if jobs > 1 {
+			refiners = nil
+			for i := 0; i < jobs; i++ {
+				var state *WhisperState
+				quiet(func() { state, err = cm.NewState(runtime.NumCPU() / jobs) })
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error creating whisper state: %v\n", err)
+					return 1
+				}
+				defer func() { quiet(func() { state.Close() }) }()
+				refiners = append(refiners, state)
+			}
 		}
+	}
 
-		multiTrack := len(streamIndices) > 1
+	// Signal handling: the first Ctrl-C cancels ctx and lets the current
+	// file clean up; a second one removes partial output and exits.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	partials := &PartialFiles{}
+	cancelSignal := SignalCleanup(cancel, partials)
+	defer cancelSignal()
 
-		for _, streamIdx := range streamIndices {
-			// Determine output path.
-			base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
-			if multiTrack {
-				lang := TrackLanguage(tracks, streamIdx)
-				base = base + "." + lang
-			}
-			outName := base + "." + format
-			var outPath string
-			if outputDir != "" {
-				outPath = filepath.Join(outputDir, outName)
-			} else {
-				outPath = filepath.Join(filepath.Dir(file), outName)
+	proc := NewProcessor(cfg, engines, partials)
+	proc.SetRefiners(refiners)
+
+	if watchMode {
+		// Files already present are transcribed unless they have subtitles,
+		// so recordings that arrived while subline was not running are not
+		// missed; new arrivals follow --overwrite. Failed files are retried
+		// later. Runs until interrupted.
+		existingCfg := cfg
+		existingCfg.Overwrite = OverwriteNever
+		existing := NewProcessor(existingCfg, engines, partials)
+		existing.SetRefiners(refiners)
+		w := NewWatcher(paths, discoverOpts, pollInterval, settle)
+		w.Prime()
+		w.Run(ctx.Done(), func(ready []MediaFile) {
+			var old, arrived []MediaFile
+			for _, f := range ready {
+				if w.Existing(f.Path) {
+					old = append(old, f)
+				} else {
+					arrived = append(arrived, f)
+				}
 			}
-
-			// Skip existing.
-			if skipExisting {
-				if _, err := os.Stat(outPath); err == nil {
-					fmt.Println("  Skipping (subtitle file exists)")
+			for _, batch := range []struct {
+				proc  *Processor
+				files []MediaFile
+			}{{existing, old}, {proc, arrived}} {
+				if len(batch.files) == 0 || ctx.Err() != nil {
 					continue
 				}
-			}
-
-			// Extract audio.
-			fmt.Printf("  Extracting audio (track %d)...\n", streamIdx)
-			var samples []float32
-			quiet(func() { samples, err = ExtractAudio(file, streamIdx) })
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "  Error extracting audio: %v\n", err)
-				continue
-			}
-
-			// Track current output for signal cleanup.
-			currentOutput = outPath
-
-			// Detect language before transcription if not specified.
-			transcribeLang := language
-			if language == "" {
-				var detected string
-				quiet(func() { detected = wm.DetectLanguage(samples) })
-				if detected != "" {
-					fmt.Printf("  Detected language: %s\n", detected)
-					transcribeLang = detected
+				batch.proc.ProcessFiles(ctx, batch.files)
+				for _, path := range batch.proc.FailedFiles() {
+					w.Failed(path)
 				}
 			}
-
-			// Transcribe with progress.
-			durationSec := float64(len(samples)) / 16000.0
-			if durationSec < 60 {
-				fmt.Printf("  Transcribing %.0fs of audio...\n", durationSec)
-			} else {
-				fmt.Printf("  Transcribing %.0f min of audio...\n", durationSec/60.0)
-			}
-
-			progress := NewProgressReporter(realStderr)
-			var segments []Segment
-			quiet(func() { segments, err = wm.Transcribe(samples, transcribeLang, progress.Update) })
-			progress.Finish()
-
-			if err != nil {
-				currentOutput = ""
-				fmt.Fprintf(os.Stderr, "  Error transcribing: %v\n", err)
-				continue
+			if ctx.Err() == nil {
+				fmt.Println("Waiting for new files...")
 			}
+		})
+		fmt.Fprintln(os.Stderr, "Interrupted.")
+		return 130
+	}
 
-			// Write subtitle file.
-			f, err := os.Create(outPath)
-			if err != nil {
-				currentOutput = ""
-				fmt.Fprintf(os.Stderr, "  Error creating output file: %v\n", err)
-				continue
-			}
+	// Process each file.
+	proc.ProcessFiles(ctx, files)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted.")
+		return 130
+	}
 
-			switch format {
-			case "vtt":
-				err = WriteVTT(f, segments)
-			default:
-				err = WriteSRT(f, segments)
-			}
-			f.Close()
-			currentOutput = ""
+	fmt.Println("All done.")
+	return 0
+}
 
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "  Error writing subtitles: %v\n", err)
-				os.Remove(outPath)
-				continue
-			}
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
 
-			elapsed := time.Since(progress.startTime)
-			em := int(elapsed.Seconds()) / 60
-			es := int(elapsed.Seconds()) % 60
-			fmt.Printf("  Done: %d segments in %dm%02ds -> %s\n\n", len(segments), em, es, outPath)
-		}
-	}
+func (l *stringList) String() string { return strings.Join(*l, ",") }
 
-	fmt.Println("All done.")
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
 
 // realStderr is a dup of the original stderr fd, unaffected by suppressCOutput.
 // The progress bar writes to this so it remains visible even when C output is muted.
 var realStderr *os.File
 
+// realStdout is the same for stdout, used by parallel runs where C output is
+// muted for the whole batch.
+var realStdout *os.File
+
 func init() {
 	fd, err := syscall.Dup(2)
 	if err == nil {
@@ -256,6 +608,12 @@ func init() {
 	} else {
 		realStderr = os.Stderr
 	}
+	fd, err = syscall.Dup(1)
+	if err == nil {
+		realStdout = os.NewFile(uintptr(fd), "realStdout")
+	} else {
+		realStdout = os.Stdout
+	}
 }
 
 // suppressCOutput redirects C-level stdout and stderr to /dev/null.
@@ -0,0 +1,21 @@
+//go:build darwin
+
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// availableMemory returns the physical memory in bytes, or 0 if unknown.
+// macOS frees cached memory on demand, so all of it is counted.
+func availableMemory() int64 {
+	s, err := syscall.Sysctl("hw.memsize")
+	if err != nil {
+		return 0
+	}
+	// Sysctl drops a trailing zero byte of the little-endian value.
+	b := make([]byte, 8)
+	copy(b, s)
+	return int64(binary.LittleEndian.Uint64(b))
+}
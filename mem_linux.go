@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// availableMemory returns the memory available to this process in bytes:
+// MemAvailable from /proc/meminfo, lowered to the cgroup limit when running
+// in a container. It returns 0 if unknown.
+func availableMemory() int64 {
+	var avail int64
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if rest, ok := strings.CutPrefix(sc.Text(), "MemAvailable:"); ok {
+				kb, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "kB")), 10, 64)
+				avail = kb << 10
+				break
+			}
+		}
+		f.Close()
+	}
+
+	// cgroup v2, then v1; "max" and huge v1 values mean no limit.
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && limit > 0 && limit < 1<<60 && (avail == 0 || limit < avail) {
+			avail = limit
+		}
+		break
+	}
+	return avail
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// availableMemory is not implemented on this platform; --model auto then
+// does not limit the model size by memory.
+func availableMemory() int64 {
+	return 0
+}
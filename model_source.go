@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const huggingFaceURL = "https://huggingface.co/"
+
+// ModelSource says where the file of a model comes from: a download into
+// the cache, or a local file used in place.
+type ModelSource struct {
+	File   string // file name in the cache
+	URL    string // where to download File from
+	Path   string // local model file; File and URL are unused
+	SHA256 string // pinned checksum, if known
+}
+
+// registryEntry is a model in the registry file.
+type registryEntry struct {
+	Source string `json:"source"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// customModels are the models registered in the registry file, by name.
+var customModels = map[string]ModelSource{}
+
+// configDirOverride allows tests to redirect the config files.
+var configDirOverride string
+
+// ConfigDir returns the directory of subline's config files, e.g.
+// ~/.config/subline, or "" if there is no home directory.
+func ConfigDir() string {
+	if configDirOverride != "" {
+		return configDirOverride
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "subline")
+}
+
+// ModelRegistryPath returns the registry file of custom models.
+func ModelRegistryPath() string {
+	if dir := ConfigDir(); dir != "" {
+		return filepath.Join(dir, "models.json")
+	}
+	return ""
+}
+
+// ConfigPath returns the general config file.
+func ConfigPath() string {
+	if dir := ConfigDir(); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return ""
+}
+
+// LoadModelMirrors sets the mirrors models are downloaded from, in order:
+// from $SUBLINE_MODEL_MIRROR, a comma-separated list of base URLs sharing
+// the bearer token in $SUBLINE_MODEL_TOKEN (if set), or else from
+// "model_mirrors" in the config file:
+//
+//	{"model_mirrors": [{"url": "https://artifacts.example.org/whisper/", "token": "..."}]}
+//
+// Without mirrors, models come from their own URL.
+func LoadModelMirrors() error {
+	if env := os.Getenv("SUBLINE_MODEL_MIRROR"); env != "" {
+		modelMirrors = nil
+		for _, u := range strings.Split(env, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				modelMirrors = append(modelMirrors, modelMirror{URL: u, Token: os.Getenv("SUBLINE_MODEL_TOKEN")})
+			}
+		}
+		return checkMirrors("SUBLINE_MODEL_MIRROR")
+	}
+
+	file := ConfigPath()
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cfg struct {
+		ModelMirrors []modelMirror `json:"model_mirrors"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	modelMirrors = cfg.ModelMirrors
+	return checkMirrors(file)
+}
+
+// checkMirrors validates the mirror URLs read from source.
+func checkMirrors(source string) error {
+	for _, m := range modelMirrors {
+		u, err := url.Parse(m.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: invalid mirror URL %q", source, m.URL)
+		}
+	}
+	return nil
+}
+
+// LoadModelRegistry reads the registry file, if there is one, and makes its
+// models available by name. Each entry has a source in any form --model
+// accepts, and optionally the file's SHA-256 checksum:
+//
+//	{
+//	  "medical": {"source": "https://example.org/ggml-medical.bin", "sha256": "..."},
+//	  "large-q5": {"source": "ggml-large-v3-q5_0.bin"}
+//	}
+func LoadModelRegistry() error {
+	file := ModelRegistryPath()
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries map[string]registryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	for name, e := range entries {
+		if _, ok := modelFiles[name]; ok || name == autoModel {
+			return fmt.Errorf("%s: model %q is built in and cannot be redefined", file, name)
+		}
+		if name == "" || strings.ContainsAny(name, `/\`) || strings.HasSuffix(name, ".bin") {
+			return fmt.Errorf("%s: invalid model name %q", file, name)
+		}
+		src, err := parseModelSource(e.Source)
+		if err != nil {
+			return fmt.Errorf("%s: model %q: %w", file, name, err)
+		}
+		if e.SHA256 != "" {
+			sum := strings.ToLower(e.SHA256)
+			if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("%s: model %q: sha256 is not a SHA-256 digest", file, name)
+			}
+			src.SHA256 = sum
+		}
+		customModels[name] = src
+	}
+	return nil
+}
+
+// file returns the base name of the model file.
+func (s ModelSource) file() string {
+	if s.Path != "" {
+		return filepath.Base(s.Path)
+	}
+	return s.File
+}
+
+// localPath returns where the model file is: in place, or in the cache.
+func (s ModelSource) localPath() string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return filepath.Join(CacheDir(), s.File)
+}
+
+// LookupModel resolves a --model value: a built-in or registered model
+// name, a local GGML file, a URL, a file of the whisper.cpp repository
+// (e.g. ggml-large-v3-q5_0.bin) or a HuggingFace repo/file reference
+// (e.g. distil-whisper/distil-large-v3-ggml/ggml-distil-large-v3.bin).
+func LookupModel(model string) (ModelSource, error) {
+	if f, ok := modelFiles[model]; ok {
+		return defaultRepoSource(f), nil
+	}
+	if src, ok := customModels[model]; ok {
+		return src, nil
+	}
+	src, err := parseModelSource(model)
+	if err != nil {
+		return ModelSource{}, fmt.Errorf("unknown model %q; valid models: %s, or a .bin file path, URL or HuggingFace repo/file",
+			model, strings.Join(allModelNames(), ", "))
+	}
+	return src, nil
+}
+
+// parseModelSource parses a model given by location rather than name.
+func parseModelSource(s string) (ModelSource, error) {
+	switch {
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+		u, err := url.Parse(s)
+		if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+			return ModelSource{}, fmt.Errorf("invalid model URL %q", s)
+		}
+		sum := sha256.Sum256([]byte(s))
+		return ModelSource{File: "url-" + hex.EncodeToString(sum[:4]) + "-" + path.Base(u.Path), URL: s}, nil
+
+	case isLocalPath(s):
+		p := s
+		if strings.HasPrefix(p, "~/") {
+			home, _ := os.UserHomeDir()
+			p = filepath.Join(home, p[2:])
+		}
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return ModelSource{}, err
+		}
+		return ModelSource{Path: p}, nil
+
+	case !strings.HasSuffix(s, ".bin"):
+		return ModelSource{}, fmt.Errorf("%q is not a model name, .bin file, URL or HuggingFace repo/file", s)
+
+	case !strings.Contains(s, "/"):
+		return defaultRepoSource(s), nil
+	}
+
+	parts := strings.SplitN(s, "/", 3)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return ModelSource{}, fmt.Errorf("%q is not a HuggingFace repo/file reference", s)
+	}
+	return ModelSource{
+		File: parts[0] + "--" + parts[1] + "--" + strings.ReplaceAll(parts[2], "/", "--"),
+		URL:  huggingFaceURL + parts[0] + "/" + parts[1] + "/resolve/main/" + parts[2],
+	}, nil
+}
+
+// isLocalPath reports whether a model reference names a local file: an
+// explicit path, or a file that exists.
+func isLocalPath(s string) bool {
+	if filepath.IsAbs(s) || strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../") || strings.HasPrefix(s, "~/") {
+		return true
+	}
+	info, err := os.Stat(s)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// defaultRepoSource is a file of the whisper.cpp model repository, with
+// its pinned checksum if there is one.
+func defaultRepoSource(file string) ModelSource {
+	return ModelSource{File: file, URL: defaultModelBaseURL + file, SHA256: modelChecksums[file]}
+}
+
+// locations returns where to download the model from: the file on each
+// mirror in turn, under its cache file name, then its own URL. Mirrors
+// replace the whisper.cpp repository, so its files are not fetched from
+// there unless it is one of them; other models fall back to their URL,
+// since mirrors rarely hold them.
+func (s ModelSource) locations() []modelMirror {
+	if len(modelMirrors) == 0 {
+		return []modelMirror{{URL: s.URL}}
+	}
+	locs := make([]modelMirror, 0, len(modelMirrors)+1)
+	for _, m := range modelMirrors {
+		locs = append(locs, modelMirror{URL: strings.TrimSuffix(m.URL, "/") + "/" + s.File, Token: m.Token})
+	}
+	if !strings.HasPrefix(s.URL, defaultModelBaseURL) {
+		locs = append(locs, modelMirror{URL: s.URL})
+	}
+	return locs
+}
+
+// allModelNames returns the built-in model names followed by the
+// registered ones, sorted.
+func allModelNames() []string {
+	custom := make([]string, 0, len(customModels))
+	for name := range customModels {
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+	return append(append([]string(nil), modelNames...), custom...)
+}
+
+// ModelLabel returns a short name for a --model value, usable in file
+// names: the model name itself, or for a file, path or URL its base name
+// without the "ggml-" prefix and ".bin" extension.
+func ModelLabel(model string) string {
+	if !strings.HasSuffix(model, ".bin") {
+		return model
+	}
+	base := path.Base(filepath.ToSlash(model))
+	return strings.TrimPrefix(strings.TrimSuffix(base, ".bin"), "ggml-")
+}
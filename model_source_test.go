@@ -0,0 +1,282 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupModel(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "ggml-medical.bin")
+	os.WriteFile(local, []byte("model"), 0644)
+
+	for _, tc := range []struct {
+		model string
+		want  ModelSource
+	}{
+		{"base", ModelSource{File: "ggml-base.bin", URL: defaultModelBaseURL + "ggml-base.bin"}},
+		{"ggml-large-v3-q5_0.bin", ModelSource{File: "ggml-large-v3-q5_0.bin", URL: defaultModelBaseURL + "ggml-large-v3-q5_0.bin"}},
+		{local, ModelSource{Path: local}},
+		{"distil-whisper/distil-large-v3-ggml/ggml-distil-large-v3.bin", ModelSource{
+			File: "distil-whisper--distil-large-v3-ggml--ggml-distil-large-v3.bin",
+			URL:  "https://huggingface.co/distil-whisper/distil-large-v3-ggml/resolve/main/ggml-distil-large-v3.bin",
+		}},
+	} {
+		got, err := LookupModel(tc.model)
+		if err != nil {
+			t.Errorf("LookupModel(%q): %v", tc.model, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("LookupModel(%q) = %+v; want %+v", tc.model, got, tc.want)
+		}
+	}
+
+	url := "https://models.example.org/v2/ggml-medical.bin"
+	got, err := LookupModel(url)
+	if err != nil || got.URL != url || !strings.HasPrefix(got.File, "url-") || !strings.HasSuffix(got.File, "-ggml-medical.bin") {
+		t.Errorf("LookupModel(%q) = %+v, %v", url, got, err)
+	}
+
+	for _, bad := range []string{"huge", "owner/file.bin", "notes.txt"} {
+		if _, err := LookupModel(bad); err == nil {
+			t.Errorf("LookupModel(%q) succeeded; want an error", bad)
+		}
+	}
+}
+
+// withRegistry loads a registry file with the given content for one test.
+func withRegistry(t *testing.T, content string) error {
+	origDir, origModels, origSums := configDirOverride, customModels, modelChecksums
+	customModels, modelChecksums = map[string]ModelSource{}, map[string]string{}
+	t.Cleanup(func() {
+		configDirOverride, customModels, modelChecksums = origDir, origModels, origSums
+	})
+	configDirOverride = t.TempDir()
+	os.WriteFile(ModelRegistryPath(), []byte(content), 0644)
+	return LoadModelRegistry()
+}
+
+func TestLoadModelRegistry(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	err := withRegistry(t, `{
+		"medical": {"source": "https://example.org/ggml-medical.bin", "sha256": "`+sum+`"},
+		"large-q5": {"source": "ggml-large-v3-q5_0.bin"}
+	}`)
+	if err != nil {
+		t.Fatalf("LoadModelRegistry: %v", err)
+	}
+	src, err := LookupModel("medical")
+	if err != nil || src.URL != "https://example.org/ggml-medical.bin" || src.SHA256 != sum {
+		t.Errorf("LookupModel(medical) = %+v, %v", src, err)
+	}
+	if len(modelChecksums) != 0 {
+		t.Errorf("registry changed the pinned checksums of built-in models: %v", modelChecksums)
+	}
+	if f, err := ModelFileName("large-q5"); err != nil || f != "ggml-large-v3-q5_0.bin" {
+		t.Errorf("ModelFileName(large-q5) = %q, %v", f, err)
+	}
+	if got := strings.Join(allModelNames(), ","); !strings.HasSuffix(got, ",large,large-q5,medical") {
+		t.Errorf("allModelNames() = %s; want registered models after the built-in ones", got)
+	}
+}
+
+func TestLoadModelRegistry_Invalid(t *testing.T) {
+	for _, content := range []string{
+		`{"turbo": {"source": "ggml-other.bin"}}`,
+		`{"x": {"source": "notes.txt"}}`,
+		`{"x": {"source": "ggml-x.bin", "sha256": "1234"}}`,
+		`{"x": `,
+	} {
+		if err := withRegistry(t, content); err == nil {
+			t.Errorf("LoadModelRegistry accepted %s", content)
+		}
+	}
+}
+
+func TestLoadModelRegistry_SharedFileName(t *testing.T) {
+	// A fine-tuned model named like the built-in large model's file.
+	local := filepath.Join(t.TempDir(), "ggml-large-v3.bin")
+	os.WriteFile(local, []byte("fine-tuned"), 0644)
+	sum := sha256Hex([]byte("fine-tuned"))
+	if err := withRegistry(t, `{"medical": {"source": "`+filepath.ToSlash(local)+`", "sha256": "`+sum+`"}}`); err != nil {
+		t.Fatal(err)
+	}
+	builtin := sha256Hex([]byte("the real large model"))
+	modelChecksums["ggml-large-v3.bin"] = builtin
+
+	if src, _ := LookupModel("large"); src.SHA256 != builtin {
+		t.Errorf("large is pinned to %s; want the built-in checksum", src.SHA256)
+	}
+	if _, err := EnsureModel("medical", ModelOptions{Verify: true}); err != nil {
+		t.Errorf("EnsureModel(medical) = %v; want it verified against its own checksum", err)
+	}
+}
+
+func TestEnsureModel_CustomSources(t *testing.T) {
+	// A local file is used in place.
+	local := filepath.Join(t.TempDir(), "ggml-medical.bin")
+	os.WriteFile(local, []byte("model"), 0644)
+	if got, err := EnsureModel(local, ModelOptions{}); err != nil || got != local {
+		t.Errorf("EnsureModel(local file) = %q, %v; want %q", got, err, local)
+	}
+	if _, err := EnsureModel(local+".missing", ModelOptions{}); err == nil {
+		t.Error("EnsureModel accepted a missing local file")
+	}
+
+	// A URL is downloaded into the cache.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("custom model"))
+	}))
+	defer srv.Close()
+	origCache := cacheDirOverride
+	cacheDirOverride = t.TempDir()
+	t.Cleanup(func() { cacheDirOverride = origCache })
+
+	got, err := EnsureModel(srv.URL+"/ggml-medical.bin", ModelOptions{})
+	if err != nil {
+		t.Fatalf("EnsureModel(URL): %v", err)
+	}
+	if filepath.Dir(got) != cacheDirOverride {
+		t.Errorf("downloaded model at %s; want it in the cache", got)
+	}
+	if data, _ := os.ReadFile(got); string(data) != "custom model" {
+		t.Errorf("downloaded model = %q", data)
+	}
+}
+
+func TestModelLabel(t *testing.T) {
+	for in, want := range map[string]string{
+		"turbo":                  "turbo",
+		"medical":                "medical",
+		"/srv/ggml-medical.bin":  "medical",
+		"ggml-large-v3-q5_0.bin": "large-v3-q5_0",
+		"distil-whisper/distil-large-v3-ggml/ggml-distil-large-v3.bin": "distil-large-v3",
+	} {
+		if got := ModelLabel(in); got != want {
+			t.Errorf("ModelLabel(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadModelMirrors(t *testing.T) {
+	orig, origDir := modelMirrors, configDirOverride
+	t.Cleanup(func() { modelMirrors, configDirOverride = orig, origDir })
+	configDirOverride = t.TempDir()
+	os.WriteFile(ConfigPath(), []byte(`{"model_mirrors": [
+		{"url": "https://artifacts.internal/whisper/", "token": "secret"},
+		{"url": "https://huggingface.co/ggerganov/whisper.cpp/resolve/main/"}
+	]}`), 0644)
+
+	if err := LoadModelMirrors(); err != nil {
+		t.Fatalf("LoadModelMirrors: %v", err)
+	}
+	want := []modelMirror{
+		{URL: "https://artifacts.internal/whisper/", Token: "secret"},
+		{URL: "https://huggingface.co/ggerganov/whisper.cpp/resolve/main/"},
+	}
+	if !reflect.DeepEqual(modelMirrors, want) {
+		t.Errorf("mirrors from config = %+v; want %+v", modelMirrors, want)
+	}
+	if u, _ := ModelURL("base"); u != "https://artifacts.internal/whisper/ggml-base.bin" {
+		t.Errorf("ModelURL(base) = %q; want the file on the first mirror", u)
+	}
+
+	// The environment takes precedence.
+	t.Setenv("SUBLINE_MODEL_MIRROR", "http://a.internal/models, http://b.internal/models/")
+	t.Setenv("SUBLINE_MODEL_TOKEN", "tok")
+	if err := LoadModelMirrors(); err != nil {
+		t.Fatalf("LoadModelMirrors: %v", err)
+	}
+	want = []modelMirror{{URL: "http://a.internal/models", Token: "tok"}, {URL: "http://b.internal/models/", Token: "tok"}}
+	if !reflect.DeepEqual(modelMirrors, want) {
+		t.Errorf("mirrors from environment = %+v; want %+v", modelMirrors, want)
+	}
+
+	t.Setenv("SUBLINE_MODEL_MIRROR", "artifacts.internal")
+	if err := LoadModelMirrors(); err == nil {
+		t.Error("LoadModelMirrors accepted a mirror without scheme")
+	}
+}
+
+func TestEnsureModel_MirrorsInOrder(t *testing.T) {
+	var got []string
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, "broken "+r.URL.Path)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, "private "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("model"))
+	}))
+	defer private.Close()
+
+	origCache, origMirrors := cacheDirOverride, modelMirrors
+	t.Cleanup(func() { cacheDirOverride, modelMirrors = origCache, origMirrors })
+	cacheDirOverride = t.TempDir()
+	modelMirrors = []modelMirror{{URL: broken.URL}, {URL: private.URL + "/models/", Token: "secret"}}
+
+	if _, err := EnsureModel("tiny", ModelOptions{}); err != nil {
+		t.Fatalf("EnsureModel: %v", err)
+	}
+	want := []string{"broken /ggml-tiny.bin", "private /models/ggml-tiny.bin Bearer secret"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q; want %q", got, want)
+	}
+}
+
+func TestModelSource_LocationsFallBackToOwnURL(t *testing.T) {
+	orig := modelMirrors
+	t.Cleanup(func() { modelMirrors = orig })
+	modelMirrors = []modelMirror{{URL: "https://artifacts.internal/whisper/", Token: "secret"}}
+
+	src, _ := LookupModel("https://example.org/models/ggml-medical.bin")
+	want := []modelMirror{
+		{URL: "https://artifacts.internal/whisper/" + src.File, Token: "secret"},
+		{URL: "https://example.org/models/ggml-medical.bin"},
+	}
+	if got := src.locations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("locations of a URL model = %+v; want %+v", got, want)
+	}
+
+	src, _ = LookupModel("tiny")
+	if got := src.locations(); len(got) != 1 || got[0].URL != "https://artifacts.internal/whisper/ggml-tiny.bin" {
+		t.Errorf("locations of a built-in model = %+v; want the mirror only", got)
+	}
+}
+
+func TestEnsureModel_Offline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("offline EnsureModel requested %s", r.URL.Path)
+	}))
+	defer srv.Close()
+	origCache, origMirrors := cacheDirOverride, modelMirrors
+	t.Cleanup(func() { cacheDirOverride, modelMirrors = origCache, origMirrors })
+	cacheDirOverride = t.TempDir()
+	modelMirrors = []modelMirror{{URL: srv.URL}}
+
+	_, err := EnsureModel("tiny", ModelOptions{Offline: true})
+	if err == nil || !strings.Contains(err.Error(), "--offline") {
+		t.Errorf("EnsureModel offline without a cached model: err = %v", err)
+	}
+
+	path := filepath.Join(cacheDirOverride, "ggml-tiny.bin")
+	os.WriteFile(path, []byte("model"), 0644)
+	if got, err := EnsureModel("tiny", ModelOptions{Offline: true}); err != nil || got != path {
+		t.Errorf("EnsureModel offline with a cached model = %q, %v", got, err)
+	}
+
+	os.WriteFile(path+".sha256", []byte(sha256Hex([]byte("other"))), 0644)
+	if _, err := EnsureModel("tiny", ModelOptions{Offline: true, Verify: true}); err == nil {
+		t.Error("EnsureModel offline accepted a corrupted model")
+	}
+}
@@ -1,32 +1,67 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strconv"
+	"strings"
+	"time"
 )
 
+// modelNames lists the friendly model names, smallest first. The ".en"
+// models only transcribe English.
+var modelNames = []string{
+	"tiny", "tiny.en", "base", "base.en", "small", "small.en",
+	"medium", "medium.en", "turbo", "large",
+}
+
 // modelFiles maps friendly model names to their GGML filenames.
 var modelFiles = map[string]string{
-	"tiny":   "ggml-tiny.bin",
-	"base":   "ggml-base.bin",
-	"small":  "ggml-small.bin",
-	"medium": "ggml-medium.bin",
-	"turbo":  "ggml-large-v3-turbo.bin",
-	"large":  "ggml-large-v3.bin",
+	"tiny":      "ggml-tiny.bin",
+	"tiny.en":   "ggml-tiny.en.bin",
+	"base":      "ggml-base.bin",
+	"base.en":   "ggml-base.en.bin",
+	"small":     "ggml-small.bin",
+	"small.en":  "ggml-small.en.bin",
+	"medium":    "ggml-medium.bin",
+	"medium.en": "ggml-medium.en.bin",
+	"turbo":     "ggml-large-v3-turbo.bin",
+	"large":     "ggml-large-v3.bin",
 }
 
+// modelChecksums pins the SHA-256 digests of the files of the whisper.cpp
+// model repository by file name. Other models are pinned by their registry
+// entry, if at all (see ModelSource.SHA256). A download of a model without
+// a pinned checksum is checked against the digest the server advertises
+// for it instead (Hugging Face sends it as X-Linked-Etag for files stored
+// in LFS).
+var modelChecksums = map[string]string{}
+
+// errNoChecksum is returned by VerifyModel when there is nothing to check
+// the file against, e.g. for a model file copied into the cache by hand.
+var errNoChecksum = errors.New("no known checksum")
+
 const defaultModelBaseURL = "https://huggingface.co/ggerganov/whisper.cpp/resolve/main/"
 
 // cacheDirOverride allows tests to redirect the cache directory.
 var cacheDirOverride string
 
-// modelBaseURLOverride allows tests to redirect model downloads.
-var modelBaseURLOverride string
+// modelMirror is a server with copies of the model files, e.g. an internal
+// artifact server, or a place a model is downloaded from.
+type modelMirror struct {
+	URL   string `json:"url"`
+	Token string `json:"token,omitempty"` // bearer token for private servers
+}
+
+// modelMirrors are tried in order instead of a model's own URL; each holds
+// the files under their cache file names. See LoadModelMirrors. Tests
+// point them at a local server.
+var modelMirrors []modelMirror
 
 // CacheDir returns the platform-appropriate cache directory for model files.
 //   - macOS: ~/Library/Caches/subline/models
@@ -52,158 +87,162 @@ func CacheDir() string {
 	return filepath.Join(base, "subline", "models")
 }
 
-// ModelFileName returns the GGML filename for the given model name.
+// ModelFileName returns the file name of the given model in the cache.
 func ModelFileName(name string) (string, error) {
-	f, ok := modelFiles[name]
-	if !ok {
-		return "", fmt.Errorf("unknown model %q; valid models: tiny, base, small, medium, turbo, large", name)
+	src, err := LookupModel(name)
+	if err != nil {
+		return "", err
+	}
+	if src.Path != "" {
+		return "", fmt.Errorf("model %q is a local file, not kept in the cache", name)
 	}
-	return f, nil
+	return src.File, nil
 }
 
-// ModelURL returns the HuggingFace download URL for the given model name.
+// ModelURL returns the download URL for the given model: its first mirror,
+// or else its own URL.
 func ModelURL(name string) (string, error) {
-	f, err := ModelFileName(name)
+	src, err := LookupModel(name)
 	if err != nil {
 		return "", err
 	}
-	base := defaultModelBaseURL
-	if modelBaseURLOverride != "" {
-		base = modelBaseURLOverride
+	if src.Path != "" {
+		return "", fmt.Errorf("model %q is a local file, not downloaded", name)
 	}
-	return base + f, nil
+	return src.locations()[0].URL, nil
+}
+
+// ModelOptions controls how EnsureModel checks and downloads a model.
+type ModelOptions struct {
+	Verify       bool          // check the checksum of a cached model before use
+	Offline      bool          // never download; fail if the model is not cached
+	Retries      int           // retries of a failed download, resuming where it stopped
+	Connections  int           // parallel range requests for one download; <= 1 = one
+	StallTimeout time.Duration // abort a request receiving no data for this long; 0 = never
 }
 
 // EnsureModel checks whether the model file already exists in the cache.
-// If it does, it returns the path immediately. Otherwise it downloads
-// the model from HuggingFace with progress output to stderr and returns
-// the local path. It retries once on download failure.
-func EnsureModel(name string) (string, error) {
-	fname, err := ModelFileName(name)
+// If it does, it returns the path immediately, after checking its SHA-256
+// checksum if opts.Verify is set. Otherwise, or if the cached file is
+// corrupted, it downloads the model from HuggingFace (or its URL) with
+// progress output to stderr and returns the local path. If another
+// process is already downloading it, it waits for that download instead.
+// A model given as a local file is used in place.
+func EnsureModel(name string, opts ModelOptions) (string, error) {
+	src, err := LookupModel(name)
 	if err != nil {
 		return "", err
 	}
+	if src.Path != "" {
+		if _, err := os.Stat(src.Path); err != nil {
+			return "", fmt.Errorf("model file: %w", err)
+		}
+		if opts.Verify {
+			if err := VerifyModel(src.Path, src.SHA256); err != nil && !errors.Is(err, errNoChecksum) {
+				return "", fmt.Errorf("model file %s: %w", src.Path, err)
+			}
+		}
+		return src.Path, nil
+	}
 
 	dir := CacheDir()
-	fpath := filepath.Join(dir, fname)
+	fpath := filepath.Join(dir, src.File)
 
 	// If the file already exists, return immediately.
-	if _, err := os.Stat(fpath); err == nil {
+	_, err = os.Stat(fpath)
+	if err == nil && !opts.Verify {
 		return fpath, nil
 	}
-
-	// Ensure the cache directory exists.
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", fmt.Errorf("creating cache directory: %w", err)
-	}
-
-	url, err := ModelURL(name)
-	if err != nil {
-		return "", err
+	if err != nil && opts.Offline {
+		return "", fmt.Errorf("model %q is not in the cache (%s) and --offline forbids downloading it; "+
+			"run 'subline models download %s' on a connected machine and copy the file there", name, fpath, name)
 	}
 
-	// Try download, retry once on failure.
-	var dlErr error
-	for attempt := 0; attempt < 2; attempt++ {
-		if attempt > 0 {
-			fmt.Fprintf(os.Stderr, "Retrying download (attempt %d)...\n", attempt+1)
+	// Only one process at a time checks and downloads the file; the others
+	// wait and then use what it downloaded. Offline, nothing is written, so
+	// the cache may be read-only.
+	var lock *downloadLock
+	if !opts.Offline {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("creating cache directory: %w", err)
 		}
-		dlErr = downloadModel(url, fpath, name)
-		if dlErr == nil {
+		var waited bool
+		lock, waited, err = lockDownload(fpath, name)
+		if err != nil {
+			return "", err
+		}
+		defer lock.unlock()
+		if _, err := os.Stat(fpath); err == nil && waited {
+			fmt.Fprintf(os.Stderr, "Model '%s' was downloaded by the other process.\n", name)
 			return fpath, nil
 		}
 	}
 
-	return "", fmt.Errorf("downloading model %q: %w", name, dlErr)
-}
-
-// downloadModel fetches the model from url and writes it to dest,
-// printing progress to stderr.
-func downloadModel(url, dest, name string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("HTTP request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned HTTP %d", resp.StatusCode)
-	}
-
-	totalBytes := resp.ContentLength
-	if totalBytes > 0 {
-		sizeMB := totalBytes / (1024 * 1024)
-		fmt.Fprintf(os.Stderr, "Downloading model '%s' (%d MB)...\n", name, sizeMB)
-	} else {
-		// Try Content-Length header as fallback.
-		if cl := resp.Header.Get("Content-Length"); cl != "" {
-			if n, parseErr := strconv.ParseInt(cl, 10, 64); parseErr == nil {
-				totalBytes = n
-				sizeMB := totalBytes / (1024 * 1024)
-				fmt.Fprintf(os.Stderr, "Downloading model '%s' (%d MB)...\n", name, sizeMB)
-			}
+	if _, err := os.Stat(fpath); err == nil {
+		if !opts.Verify {
+			return fpath, nil
 		}
-		if totalBytes <= 0 {
-			fmt.Fprintf(os.Stderr, "Downloading model '%s'...\n", name)
+		err := VerifyModel(fpath, src.SHA256)
+		switch {
+		case err == nil:
+			fmt.Fprintf(os.Stderr, "Model '%s' checksum OK.\n", name)
+			return fpath, nil
+		case errors.Is(err, errNoChecksum):
+			fmt.Fprintf(os.Stderr, "Model '%s' is unverified: no checksum is known for it.\n", name)
+			return fpath, nil
 		}
+		if opts.Offline {
+			return "", fmt.Errorf("model %q is corrupted (%v) and cannot be downloaded again offline", name, err)
+		}
+		fmt.Fprintf(os.Stderr, "Model '%s' is corrupted (%v), downloading it again.\n", name, err)
 	}
 
-	// Write to a temporary file first, then rename (atomic-ish).
-	tmpPath := dest + ".tmp"
-	out, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("creating temp file: %w", err)
-	}
-	defer func() {
-		out.Close()
-		os.Remove(tmpPath) // clean up on failure; no-op if already renamed
-	}()
-
-	// Wrap the reader with a progress counter.
-	reader := &progressReader{
-		reader:     resp.Body,
-		totalBytes: totalBytes,
-		name:       name,
+	// Try each mirror in turn.
+	locs := src.locations()
+	for i, loc := range locs {
+		err = downloadModel(loc, fpath, name, src.SHA256, opts, lock)
+		if err == nil {
+			return fpath, nil
+		}
+		if i+1 < len(locs) {
+			fmt.Fprintf(os.Stderr, "Download from %s failed (%v), trying the next mirror.\n", loc.URL, err)
+		}
 	}
+	return "", fmt.Errorf("downloading model %q: %w", name, err)
+}
 
-	if _, err := io.Copy(out, reader); err != nil {
-		return fmt.Errorf("writing model data: %w", err)
+// VerifyModel checks the SHA-256 checksum of the model file at path
+// against pinned, the checksum of its ModelSource, or else the one recorded
+// when it was downloaded. It returns errNoChecksum if neither is known.
+func VerifyModel(path, pinned string) error {
+	want := pinned
+	if want == "" {
+		data, err := os.ReadFile(path + ".sha256")
+		if err != nil {
+			return errNoChecksum
+		}
+		want = strings.TrimSpace(string(data))
 	}
-
-	if err := out.Close(); err != nil {
-		return fmt.Errorf("closing temp file: %w", err)
+	got, err := fileSHA256(path)
+	if err != nil {
+		return err
 	}
-
-	// Rename temp file to final destination.
-	if err := os.Rename(tmpPath, dest); err != nil {
-		return fmt.Errorf("moving temp file: %w", err)
+	if got != want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
 	}
-
-	fmt.Fprintf(os.Stderr, "Model '%s' downloaded successfully.\n", name)
 	return nil
 }
 
-// progressReader wraps an io.Reader and prints download progress to stderr.
-type progressReader struct {
-	reader      io.Reader
-	totalBytes  int64
-	read        int64
-	name        string
-	lastPercent int
-}
-
-func (pr *progressReader) Read(p []byte) (int, error) {
-	n, err := pr.reader.Read(p)
-	pr.read += int64(n)
-
-	if pr.totalBytes > 0 {
-		percent := int(pr.read * 100 / pr.totalBytes)
-		// Print at every 10% increment to avoid excessive output.
-		if percent/10 > pr.lastPercent/10 {
-			fmt.Fprintf(os.Stderr, "  %d%%\n", percent)
-			pr.lastPercent = percent
-		}
+// fileSHA256 returns the hex SHA-256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
 	}
-
-	return n, err
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// modelsUsage is the help for "subline models".
+const modelsUsage = `Usage: subline models <command> [args]
+
+Commands:
+  list                     List known models and what is in the cache
+  download <model...>      Download models ahead of time (e.g. for offline machines)
+  remove <model...>        Delete cached models
+  verify [model...]        Check the SHA-256 checksums of cached models (default: all)
+  path [model]             Print the cache directory, or a model's file path
+  prune [--keep model,...] Delete partial downloads (except running ones) and
+                           stale checkpoints; with --keep, also every cached
+                           model not listed
+
+Models are the built-in ones, those registered in the registry file
+(see 'subline models list'), or given as a .bin path, URL or
+HuggingFace repo/file.
+`
+
+// runModels runs "subline models <command>" and returns the exit status.
+func runModels(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, modelsUsage)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	fs := flag.NewFlagSet("subline models "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts ModelOptions
+	var keep string
+	switch args[0] {
+	case "download":
+		addDownloadFlags(fs, &opts)
+		fs.Usage = func() {
+			fmt.Fprintf(stderr, "Usage: subline models download [options] <model...>\n")
+			printDownloadUsage(stderr)
+		}
+	case "prune":
+		fs.StringVar(&keep, "keep", "", "Comma-separated models to keep; all others are removed")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	names := fs.Args()
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = modelsList(stdout)
+	case "download":
+		if len(names) == 0 {
+			err = errors.New("name the models to download, e.g. 'subline models download base'")
+		} else if err = checkDownloadOptions(opts); err == nil {
+			err = modelsDownload(stdout, names, opts)
+		}
+	case "remove":
+		if len(names) == 0 {
+			err = errors.New("name the models to remove")
+		} else {
+			err = modelsRemove(stdout, names)
+		}
+	case "verify":
+		err = modelsVerify(stdout, names)
+	case "path":
+		err = modelsPath(stdout, names)
+	case "prune":
+		err = modelsPrune(stdout, keep)
+	default:
+		fmt.Fprintf(stderr, "Error: unknown command 'models %s'\n\n%s", args[0], modelsUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// modelsList prints every known model with its cached size, followed by
+// any other files found in the cache.
+func modelsList(w io.Writer) error {
+	dir := CacheDir()
+	known := map[string]bool{}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "MODEL\tFILE\tCACHED\n")
+	for _, name := range allModelNames() {
+		src, _ := LookupModel(name)
+		if src.Path != "" {
+			cached := "missing"
+			if info, err := os.Stat(src.Path); err == nil {
+				cached = "local, " + formatSize(info.Size())
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, src.Path, cached)
+			continue
+		}
+		fname := src.File
+		known[fname] = true
+		cached := "-"
+		if info, err := os.Stat(filepath.Join(dir, fname)); err == nil {
+			cached = formatSize(info.Size())
+		} else if size := partialSize(filepath.Join(dir, fname)); size > 0 {
+			cached = "partial, " + formatSize(size)
+			if downloadInProgress(filepath.Join(dir, fname)) {
+				cached += ", downloading"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, fname, cached)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range entries {
+		base := strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".sha256"), ".lock")
+		if model, ok := isPartialDownload(e.Name()); ok {
+			base = model
+		}
+		if e.IsDir() || known[base] || strings.HasSuffix(e.Name(), ".lock") {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			fmt.Fprintf(tw, "\t%s\t%s\n", e.Name(), formatSize(info.Size()))
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nCache: %s\n", dir)
+	if reg := ModelRegistryPath(); reg != "" {
+		fmt.Fprintf(w, "Registry: %s\n", reg)
+	}
+	return nil
+}
+
+// modelsDownload makes sure the named models are in the cache.
+func modelsDownload(w io.Writer, names []string, opts ModelOptions) error {
+	for _, name := range names {
+		if _, err := ModelFileName(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		path, err := EnsureModel(name, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Model '%s' is ready: %s\n", name, path)
+	}
+	return nil
+}
+
+// modelsRemove deletes the named models from the cache, along with their
+// checksums and partial downloads.
+func modelsRemove(w io.Writer, names []string) error {
+	var missing []string
+	for _, name := range names {
+		fname, err := ModelFileName(name)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(CacheDir(), fname)
+		if downloadInProgress(path) {
+			return fmt.Errorf("model '%s' is being downloaded by another subline process", name)
+		}
+		removed := false
+		for _, p := range append([]string{path, path + ".sha256"}, partialDownloads(path)...) {
+			if err := os.Remove(p); err == nil {
+				removed = true
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if removed {
+			fmt.Fprintf(w, "Removed model '%s'\n", name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not in the cache: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// modelsVerify checks the named models, or all cached ones, against their
+// checksums. It fails if any is corrupted.
+func modelsVerify(w io.Writer, names []string) error {
+	if len(names) == 0 {
+		for _, name := range allModelNames() {
+			if path, err := modelFilePath(name); err == nil {
+				if _, err := os.Stat(path); err == nil {
+					names = append(names, name)
+				}
+			}
+		}
+		if len(names) == 0 {
+			fmt.Fprintf(w, "No models in the cache.\n")
+			return nil
+		}
+	}
+
+	var bad []string
+	for _, name := range names {
+		src, err := LookupModel(name)
+		if err != nil {
+			return err
+		}
+		path := src.localPath()
+		if _, err = os.Stat(path); err == nil {
+			err = VerifyModel(path, src.SHA256)
+		}
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "%s: OK\n", name)
+		case errors.Is(err, errNoChecksum):
+			fmt.Fprintf(w, "%s: unverified (no known checksum)\n", name)
+		case os.IsNotExist(err):
+			fmt.Fprintf(w, "%s: not in the cache\n", name)
+			bad = append(bad, name)
+		default:
+			fmt.Fprintf(w, "%s: CORRUPTED (%v)\n", name, err)
+			bad = append(bad, name)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s failed verification; download again with 'subline models remove %s' and 'subline models download %s'",
+			strings.Join(bad, ", "), bad[0], bad[0])
+	}
+	return nil
+}
+
+// modelsPath prints the cache directory, or the path of a model's file.
+func modelsPath(w io.Writer, names []string) error {
+	if len(names) == 0 {
+		fmt.Fprintln(w, CacheDir())
+		return nil
+	}
+	for _, name := range names {
+		path, err := modelFilePath(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, path)
+	}
+	return nil
+}
+
+// modelFilePath returns where the file of a model is, or will be once it
+// is downloaded.
+func modelFilePath(name string) (string, error) {
+	src, err := LookupModel(name)
+	if err != nil {
+		return "", err
+	}
+	return src.localPath(), nil
+}
+
+// modelsPrune deletes partial downloads, checksums of missing models and
+// stale checkpoints. If keep lists models, every other cached model is
+// removed as well.
+func modelsPrune(w io.Writer, keep string) error {
+	dir := CacheDir()
+	keepFiles := map[string]bool{}
+	if keep != "" {
+		for _, name := range strings.Split(keep, ",") {
+			fname, err := ModelFileName(strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			keepFiles[fname] = true
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var remove []string
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case e.IsDir():
+		case strings.HasSuffix(name, ".lock"):
+			// Kept: removing a lock file could break a running download.
+		case strings.HasSuffix(name, ".tmp"):
+			if model, _ := isPartialDownload(name); !downloadInProgress(filepath.Join(dir, model)) {
+				remove = append(remove, name)
+			}
+		case strings.HasSuffix(name, ".sha256"):
+			model := strings.TrimSuffix(name, ".sha256")
+			if _, err := os.Stat(filepath.Join(dir, model)); err != nil || (keep != "" && !keepFiles[model]) {
+				remove = append(remove, name)
+			}
+		case keep != "" && !keepFiles[name]:
+			remove = append(remove, name)
+		}
+	}
+	sort.Strings(remove)
+	var freed int64
+	for _, name := range remove {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil {
+			freed += info.Size()
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Removed %s\n", name)
+	}
+
+	n, err := pruneCheckpoints()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		fmt.Fprintf(w, "Removed %d stale checkpoint(s)\n", n)
+	}
+	fmt.Fprintf(w, "Freed %s.\n", formatSize(freed))
+	return nil
+}
+
+// formatSize formats a byte count like "142 MB" or "1.5 GB".
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d B", n)
+}
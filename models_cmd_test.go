@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// modelsCache points the model cache and checkpoints at temp directories
+// holding the given files.
+func modelsCache(t *testing.T, files map[string]string) string {
+	origCache, origCheckpoints := cacheDirOverride, checkpointDirOverride
+	cacheDirOverride = t.TempDir()
+	checkpointDirOverride = t.TempDir()
+	t.Cleanup(func() {
+		cacheDirOverride = origCache
+		checkpointDirOverride = origCheckpoints
+	})
+	for name, data := range files {
+		os.WriteFile(filepath.Join(cacheDirOverride, name), []byte(data), 0644)
+	}
+	return cacheDirOverride
+}
+
+func runModelsTest(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = runModels(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestModelsList(t *testing.T) {
+	modelsCache(t, map[string]string{
+		"ggml-base.bin":      "model",
+		"ggml-small.bin.tmp": "part",
+		"ggml-custom.bin":    "other",
+	})
+	code, out, _ := runModelsTest("list")
+	if code != 0 {
+		t.Fatalf("models list exited %d", code)
+	}
+	for _, want := range []string{
+		"base       ggml-base.bin",
+		"5 B",
+		"partial, 4 B",
+		"ggml-custom.bin",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("models list output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestModelsRemoveAndPath(t *testing.T) {
+	dir := modelsCache(t, map[string]string{
+		"ggml-base.bin":        "model",
+		"ggml-base.bin.sha256": "x",
+		// A model with only partial downloads, new and old style.
+		"ggml-small.bin.download-7.tmp": "part",
+		"ggml-small.bin.tmp":            "part",
+	})
+	if code, out, _ := runModelsTest("path", "base"); code != 0 || strings.TrimSpace(out) != filepath.Join(dir, "ggml-base.bin") {
+		t.Errorf("models path base = %d, %q", code, out)
+	}
+	if code, out, _ := runModelsTest("path"); code != 0 || strings.TrimSpace(out) != dir {
+		t.Errorf("models path = %d, %q; want the cache directory", code, out)
+	}
+
+	if code, _, errOut := runModelsTest("remove", "base", "small"); code != 0 {
+		t.Fatalf("models remove exited %d: %s", code, errOut)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left after remove", len(entries))
+	}
+	if code, _, errOut := runModelsTest("remove", "base"); code != 1 || !strings.Contains(errOut, "not in the cache") {
+		t.Errorf("removing a missing model = %d, %q; want an error", code, errOut)
+	}
+}
+
+func TestModelsVerify(t *testing.T) {
+	modelsCache(t, map[string]string{
+		"ggml-tiny.bin":        "model",
+		"ggml-tiny.bin.sha256": sha256Hex([]byte("model")),
+		"ggml-base.bin":        "damaged",
+		"ggml-base.bin.sha256": sha256Hex([]byte("model")),
+		"ggml-small.bin":       "no checksum",
+	})
+	code, out, errOut := runModelsTest("verify")
+	if code != 1 {
+		t.Errorf("models verify with a corrupted model exited %d; want 1", code)
+	}
+	for _, want := range []string{"tiny: OK", "base: CORRUPTED", "small: unverified"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("models verify output missing %q:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(errOut, "subline models remove base") {
+		t.Errorf("models verify error does not say how to fix it: %q", errOut)
+	}
+	if code, _, _ := runModelsTest("verify", "tiny"); code != 0 {
+		t.Errorf("models verify tiny exited %d; want 0", code)
+	}
+}
+
+func TestModelsPrune(t *testing.T) {
+	dir := modelsCache(t, map[string]string{
+		"ggml-tiny.bin":         "model",
+		"ggml-tiny.bin.sha256":  "x",
+		"ggml-base.bin":         "model",
+		"ggml-small.bin.tmp":    "part",
+		"ggml-large.bin.sha256": "x",
+	})
+	// A checkpoint of a deleted source file.
+	src := filepath.Join(t.TempDir(), "gone.mkv")
+	os.WriteFile(src, nil, 0644)
+	c := newCheckpointer(src, 0, "base", "en", 1<<62)
+	c.Add(Segment{Text: "x"})
+	c.Save()
+	os.Remove(src)
+
+	if code, out, errOut := runModelsTest("prune"); code != 0 || !strings.Contains(out, "1 stale checkpoint") {
+		t.Fatalf("models prune = %d, %q, %q", code, out, errOut)
+	}
+	left := func() string {
+		entries, _ := os.ReadDir(dir)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return strings.Join(names, " ")
+	}
+	if got, want := left(), "ggml-base.bin ggml-tiny.bin ggml-tiny.bin.sha256"; got != want {
+		t.Errorf("after prune: %s; want %s", got, want)
+	}
+
+	if code, _, errOut := runModelsTest("prune", "--keep", "tiny"); code != 0 {
+		t.Fatalf("models prune --keep exited %d: %s", code, errOut)
+	}
+	if got, want := left(), "ggml-tiny.bin ggml-tiny.bin.sha256"; got != want {
+		t.Errorf("after prune --keep tiny: %s; want %s", got, want)
+	}
+}
+
+func TestModelsDownload(t *testing.T) {
+	data := []byte("fake-ggml-model-binary-content")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+	dir := modelsCache(t, nil)
+	orig := modelMirrors
+	modelMirrors = []modelMirror{{URL: srv.URL + "/"}}
+	t.Cleanup(func() { modelMirrors = orig })
+
+	if code, _, errOut := runModelsTest("download", "--download-retries", "0", "tiny", "base"); code != 0 {
+		t.Fatalf("models download exited %d: %s", code, errOut)
+	}
+	for _, f := range []string{"ggml-tiny.bin", "ggml-base.bin"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, f)); !bytes.Equal(got, data) {
+			t.Errorf("%s not downloaded", f)
+		}
+	}
+	if code, _, errOut := runModelsTest("download", "nonexistent"); code != 1 || !strings.Contains(errOut, "unknown model") {
+		t.Errorf("downloading an unknown model = %d, %q", code, errOut)
+	}
+}
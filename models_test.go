@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -158,7 +161,7 @@ func TestModelEnsure_ExistingFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, err := EnsureModel("tiny")
+	got, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Fatalf("EnsureModel returned error: %v", err)
 	}
@@ -180,15 +183,15 @@ func TestModelEnsure_DownloadsMissing(t *testing.T) {
 	// Use a temp directory as cache and override the base URL.
 	tmpDir := t.TempDir()
 	origCacheDir := cacheDirOverride
-	origBaseURL := modelBaseURLOverride
+	origMirrors := modelMirrors
 	cacheDirOverride = tmpDir
-	modelBaseURLOverride = srv.URL + "/"
+	modelMirrors = []modelMirror{{URL: srv.URL + "/"}}
 	t.Cleanup(func() {
 		cacheDirOverride = origCacheDir
-		modelBaseURLOverride = origBaseURL
+		modelMirrors = origMirrors
 	})
 
-	got, err := EnsureModel("tiny")
+	got, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Fatalf("EnsureModel returned error: %v", err)
 	}
@@ -206,10 +209,18 @@ func TestModelEnsure_DownloadsMissing(t *testing.T) {
 	if string(data) != string(modelData) {
 		t.Errorf("downloaded file content = %q; want %q", string(data), string(modelData))
 	}
+	// Nothing was known to check the file against, so no checksum may be
+	// recorded that later verification would trust.
+	if _, err := os.Stat(wantPath + ".sha256"); err == nil {
+		t.Error("a checksum was recorded for an unverified download")
+	}
+	if err := VerifyModel(wantPath, ""); !errors.Is(err, errNoChecksum) {
+		t.Errorf("VerifyModel of an unverified download = %v; want errNoChecksum", err)
+	}
 }
 
 func TestModelEnsure_InvalidModel(t *testing.T) {
-	_, err := EnsureModel("nonexistent")
+	_, err := EnsureModel("nonexistent", ModelOptions{})
 	if err == nil {
 		t.Error("EnsureModel(nonexistent) should return an error")
 	}
@@ -224,16 +235,114 @@ func TestModelEnsure_HTTPError(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	origCacheDir := cacheDirOverride
-	origBaseURL := modelBaseURLOverride
+	origMirrors := modelMirrors
 	cacheDirOverride = tmpDir
-	modelBaseURLOverride = srv.URL + "/"
+	modelMirrors = []modelMirror{{URL: srv.URL + "/"}}
 	t.Cleanup(func() {
 		cacheDirOverride = origCacheDir
-		modelBaseURLOverride = origBaseURL
+		modelMirrors = origMirrors
 	})
 
-	_, err := EnsureModel("tiny")
+	_, err := EnsureModel("tiny", ModelOptions{})
 	if err == nil {
 		t.Error("EnsureModel should return error on HTTP 404")
 	}
 }
+
+// ---------------------------------------------------------------------------
+// Checksum tests
+// ---------------------------------------------------------------------------
+
+// modelServer serves data as every model file, advertising etag (if
+// non-empty) as its SHA-256 digest, and counts the downloads.
+func modelServer(t *testing.T, data []byte, etag string, downloads *int) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*downloads++
+		if etag != "" {
+			w.Header().Set("X-Linked-Etag", `"`+etag+`"`)
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	origCacheDir, origMirrors := cacheDirOverride, modelMirrors
+	cacheDirOverride = t.TempDir()
+	modelMirrors = []modelMirror{{URL: srv.URL + "/"}}
+	t.Cleanup(func() {
+		cacheDirOverride = origCacheDir
+		modelMirrors = origMirrors
+	})
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestModelEnsure_VerifiesAndRedownloadsCorrupted(t *testing.T) {
+	data := []byte("fake-ggml-model-binary-content")
+	var downloads int
+	modelServer(t, data, sha256Hex(data), &downloads)
+
+	path, err := EnsureModel("tiny", ModelOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("EnsureModel returned error: %v", err)
+	}
+	if err := VerifyModel(path, ""); err != nil {
+		t.Fatalf("VerifyModel after download: %v", err)
+	}
+
+	// Truncate the cached file, as an interrupted copy would.
+	os.WriteFile(path, data[:10], 0644)
+	if err := VerifyModel(path, ""); err == nil {
+		t.Fatal("VerifyModel accepted a truncated file")
+	}
+	if _, err := EnsureModel("tiny", ModelOptions{}); err != nil || downloads != 1 {
+		t.Fatalf("EnsureModel without verify: err = %v, %d downloads; want cached file used", err, downloads)
+	}
+	if _, err := EnsureModel("tiny", ModelOptions{Verify: true}); err != nil {
+		t.Fatalf("EnsureModel with verify: %v", err)
+	}
+	if downloads != 2 {
+		t.Errorf("%d downloads; want the corrupted file downloaded again", downloads)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(data) {
+		t.Errorf("model file = %q after re-download; want %q", got, data)
+	}
+}
+
+func TestModelEnsure_ChecksumMismatch(t *testing.T) {
+	var downloads int
+	modelServer(t, []byte("corrupted in transit"), sha256Hex([]byte("the real model")), &downloads)
+
+	_, err := EnsureModel("tiny", ModelOptions{})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("EnsureModel error = %v; want a checksum mismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(CacheDir(), "ggml-tiny.bin")); err == nil {
+		t.Error("a model file failing its checksum was kept")
+	}
+}
+
+func TestModelEnsure_PinnedChecksum(t *testing.T) {
+	data := []byte("fake-ggml-model-binary-content")
+	var downloads int
+	// The server advertises the digest of what it sends, but the pinned
+	// checksum wins.
+	modelServer(t, data, sha256Hex(data), &downloads)
+	orig := modelChecksums
+	modelChecksums = map[string]string{"ggml-tiny.bin": sha256Hex([]byte("the real model"))}
+	t.Cleanup(func() { modelChecksums = orig })
+
+	if _, err := EnsureModel("tiny", ModelOptions{}); err == nil {
+		t.Fatal("EnsureModel accepted a download not matching the pinned checksum")
+	}
+}
+
+func TestVerifyModel_NoChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ggml-custom.bin")
+	os.WriteFile(path, []byte("model"), 0644)
+	if err := VerifyModel(path, ""); !errors.Is(err, errNoChecksum) {
+		t.Errorf("VerifyModel without checksum = %v; want errNoChecksum", err)
+	}
+}
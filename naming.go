@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// OutputName holds the values substituted into an output filename template.
+type OutputName struct {
+	Name   string // source file name without extension
+	Lang   string // language code in any known convention; "" if unknown
+	Track  int    // audio stream index
+	Model  string // whisper model name
+	Format string // subtitle format, e.g. "srt"
+	Forced bool   // subtitles are marked as forced
+	SDH    bool   // subtitles are marked as SDH (for the deaf and hard of hearing)
+}
+
+// Default templates reproduce subline's original naming: the language is
+// only added when several tracks of one file are transcribed.
+const (
+	defaultSingleTrackTemplate = "{name}.{format}"
+	defaultMultiTrackTemplate  = "{name}.{lang}.{format}"
+)
+
+// outputPresets maps --output-template preset names to templates following
+// each media server's external subtitle naming convention.
+var outputPresets = map[string]string{
+	"plex":     "{name}.{lang2}.{forced}.{sdh}.{format}",
+	"jellyfin": "{name}.{lang3}.{sdh}.{forced}.{format}",
+	"kodi":     "{name}.{lang2}.{forced}.{sdh}.{format}",
+}
+
+// templateToken matches a {token} placeholder in an output template.
+var templateToken = regexp.MustCompile(`\{([a-z0-9]+)\}`)
+
+// templateTokens lists the placeholders understood by RenderOutputName.
+var templateTokens = map[string]bool{
+	"name": true, "lang": true, "lang2": true, "lang3": true, "track": true,
+	"model": true, "format": true, "forced": true, "sdh": true,
+}
+
+// ResolveOutputTemplate expands a preset name to its template and validates
+// the result. An empty value is returned unchanged, meaning the default
+// naming applies.
+func ResolveOutputTemplate(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if tmpl, ok := outputPresets[strings.ToLower(value)]; ok {
+		return tmpl, nil
+	}
+	for _, m := range templateToken.FindAllStringSubmatch(value, -1) {
+		if !templateTokens[m[1]] {
+			return "", fmt.Errorf("unknown token {%s} in output template; presets: %s", m[1], strings.Join(presetNames(), ", "))
+		}
+	}
+	if !strings.Contains(value, "{") {
+		return "", fmt.Errorf("output template %q has no tokens and is not a preset (%s)", value, strings.Join(presetNames(), ", "))
+	}
+	if escapesDir(value) {
+		return "", fmt.Errorf("output template %q must stay inside the output directory (no absolute paths or '..')", value)
+	}
+	return value, nil
+}
+
+// escapesDir reports whether the relative name would leave the directory
+// it is joined to: it is absolute, or starts with ".." once cleaned.
+func escapesDir(name string) bool {
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return true
+	}
+	clean := filepath.Clean(name)
+	return clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// presetNames returns the sorted names of the output presets.
+func presetNames() []string {
+	names := make([]string, 0, len(outputPresets))
+	for name := range outputPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TemplateUsesLanguage reports whether tmpl contains a language token.
+func TemplateUsesLanguage(tmpl string) bool {
+	return strings.Contains(tmpl, "{lang}") || strings.Contains(tmpl, "{lang2}") || strings.Contains(tmpl, "{lang3}")
+}
+
+// RenderOutputName substitutes n into tmpl. {lang} is written in langStyle
+// (see --lang-codes), {lang2} as ISO 639-1 and {lang3} as ISO 639-2/B;
+// unknown languages render as "und". {forced} and {sdh} render as "forced"
+// and "sdh" when set and as nothing otherwise, in which case the separator
+// in front of them is dropped too.
+func RenderOutputName(tmpl string, n OutputName, langStyle string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range templateToken.FindAllStringSubmatchIndex(tmpl, -1) {
+		b.WriteString(tmpl[last:loc[0]])
+		last = loc[1]
+
+		val := tokenValue(tmpl[loc[2]:loc[3]], n, langStyle)
+		if val == "" {
+			// Drop the separator that would have preceded the value.
+			s := b.String()
+			if len(s) > 0 && strings.ContainsRune(".-_ ", rune(s[len(s)-1])) {
+				b.Reset()
+				b.WriteString(s[:len(s)-1])
+			}
+			continue
+		}
+		b.WriteString(val)
+	}
+	b.WriteString(tmpl[last:])
+	return b.String()
+}
+
+// OutputPath renders tmpl for n and joins the result to dir. It fails if
+// the rendered name would land outside dir, e.g. through a token value
+// containing "..".
+func OutputPath(dir, tmpl string, n OutputName, langStyle string) (string, error) {
+	name := RenderOutputName(tmpl, n, langStyle)
+	if escapesDir(name) || filepath.Clean(name) == "." {
+		return "", fmt.Errorf("output name %q is outside the output directory", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// tokenValue returns the rendered value of a single template token.
+func tokenValue(token string, n OutputName, langStyle string) string {
+	switch token {
+	case "name":
+		return n.Name
+	case "lang":
+		return NormalizeLanguage(n.Lang, langStyle)
+	case "lang2":
+		return NormalizeLanguage(n.Lang, LangStyleISO1)
+	case "lang3":
+		return NormalizeLanguage(n.Lang, LangStyleISO2B)
+	case "track":
+		return strconv.Itoa(n.Track)
+	case "model":
+		return n.Model
+	case "format":
+		return n.Format
+	case "forced":
+		if n.Forced {
+			return "forced"
+		}
+	case "sdh":
+		if n.SDH {
+			return "sdh"
+		}
+	}
+	return ""
+}
+
+// Overwrite policies accepted by --overwrite.
+const (
+	OverwriteAlways = "always" // replace existing files
+	OverwriteNever  = "never"  // skip outputs whose file already exists
+	OverwriteRename = "rename" // pick a free name next to the existing file
+)
+
+// ValidOverwritePolicy reports whether policy is one of the Overwrite constants.
+func ValidOverwritePolicy(policy string) bool {
+	switch policy {
+	case OverwriteAlways, OverwriteNever, OverwriteRename:
+		return true
+	}
+	return false
+}
+
+// OutputPlanner hands out output paths for a run so that no two outputs
+// share a file, and applies the overwrite policy to files already on disk.
+// It is safe for concurrent use.
+type OutputPlanner struct {
+	mu      sync.Mutex
+	policy  string
+	claimed map[string]bool
+	exists  func(path string) bool
+}
+
+// NewOutputPlanner creates an OutputPlanner with the given overwrite policy.
+func NewOutputPlanner(policy string) *OutputPlanner {
+	return &OutputPlanner{
+		policy:  policy,
+		claimed: map[string]bool{},
+		exists: func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		},
+	}
+}
+
+// Claim reserves an output path for one track. If path was already handed
+// out in this run (or exists on disk under the rename policy), the name is
+// disambiguated with the track title, then the track index, then a counter.
+// Claim returns ok=false when the never policy says the output must be
+// skipped because the file exists.
+func (p *OutputPlanner) Claim(path, title string, track int) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	taken := func(c string) bool {
+		return p.claimed[c] || (p.policy == OverwriteRename && p.exists(c))
+	}
+
+	chosen := path
+	if taken(chosen) {
+		ext := filepath.Ext(path)
+		stem := strings.TrimSuffix(path, ext)
+		var candidates []string
+		if slug := slugify(title); slug != "" {
+			candidates = append(candidates, stem+"."+slug+ext)
+		}
+		candidates = append(candidates, stem+".track"+strconv.Itoa(track)+ext)
+		chosen = ""
+		for _, c := range candidates {
+			if !taken(c) {
+				chosen = c
+				break
+			}
+		}
+		for n := 2; chosen == ""; n++ {
+			if c := stem + ".track" + strconv.Itoa(track) + "." + strconv.Itoa(n) + ext; !taken(c) {
+				chosen = c
+			}
+		}
+	}
+
+	p.claimed[chosen] = true
+	if p.policy == OverwriteNever && p.exists(chosen) {
+		return chosen, false
+	}
+	return chosen, true
+}
+
+// slugify lower-cases s, drops apostrophes and replaces runs of anything
+// other than letters and digits with a single dash, for use in file names.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r == '\'' || r == '’' {
+			continue // "Director's" -> "directors"
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRenderOutputName_Default(t *testing.T) {
+	n := OutputName{Name: "movie", Lang: "ru", Format: "srt"}
+	if got := RenderOutputName(defaultSingleTrackTemplate, n, LangStyleISO2B); got != "movie.srt" {
+		t.Errorf("single-track default = %q; want %q", got, "movie.srt")
+	}
+	if got := RenderOutputName(defaultMultiTrackTemplate, n, LangStyleISO2B); got != "movie.rus.srt" {
+		t.Errorf("multi-track default = %q; want %q", got, "movie.rus.srt")
+	}
+}
+
+func TestRenderOutputName_Plex(t *testing.T) {
+	n := OutputName{Name: "Movie (2020)", Lang: "eng", Format: "srt", SDH: true}
+	got := RenderOutputName(outputPresets["plex"], n, LangStyleISO2B)
+	if got != "Movie (2020).en.sdh.srt" {
+		t.Errorf("plex = %q; want %q", got, "Movie (2020).en.sdh.srt")
+	}
+}
+
+func TestRenderOutputName_FlagsDropSeparators(t *testing.T) {
+	n := OutputName{Name: "show", Lang: "de", Format: "vtt"}
+	got := RenderOutputName("{name}.{lang3}.{forced}.{sdh}.{format}", n, LangStyleISO1)
+	if got != "show.ger.vtt" {
+		t.Errorf("got %q; want %q", got, "show.ger.vtt")
+	}
+
+	n.Forced = true
+	got = RenderOutputName("{name}.{lang3}.{forced}.{sdh}.{format}", n, LangStyleISO1)
+	if got != "show.ger.forced.vtt" {
+		t.Errorf("got %q; want %q", got, "show.ger.forced.vtt")
+	}
+}
+
+func TestRenderOutputName_AllTokens(t *testing.T) {
+	n := OutputName{Name: "a", Lang: "fr", Track: 2, Model: "tiny", Format: "srt"}
+	got := RenderOutputName("{model}/{name}-{track}-{lang}-{lang2}-{lang3}.{format}", n, LangStyleBCP47)
+	if got != "tiny/a-2-fr-fr-fre.srt" {
+		t.Errorf("got %q; want %q", got, "tiny/a-2-fr-fr-fre.srt")
+	}
+}
+
+func TestRenderOutputName_UnknownLanguage(t *testing.T) {
+	n := OutputName{Name: "clip", Format: "srt"}
+	if got := RenderOutputName("{name}.{lang2}.{format}", n, LangStyleISO1); got != "clip.und.srt" {
+		t.Errorf("got %q; want %q", got, "clip.und.srt")
+	}
+}
+
+func TestResolveOutputTemplate(t *testing.T) {
+	if got, err := ResolveOutputTemplate(""); err != nil || got != "" {
+		t.Errorf("ResolveOutputTemplate(\"\") = %q, %v; want empty", got, err)
+	}
+	if got, err := ResolveOutputTemplate("Jellyfin"); err != nil || got != outputPresets["jellyfin"] {
+		t.Errorf("ResolveOutputTemplate(Jellyfin) = %q, %v", got, err)
+	}
+	if got, err := ResolveOutputTemplate("{name}.{lang}.{format}"); err != nil || got != "{name}.{lang}.{format}" {
+		t.Errorf("custom template = %q, %v", got, err)
+	}
+	if _, err := ResolveOutputTemplate("{name}.{bogus}.srt"); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("expected unknown token error, got %v", err)
+	}
+	if _, err := ResolveOutputTemplate("emby"); err == nil {
+		t.Error("expected error for unknown preset")
+	}
+	for _, tmpl := range []string{"/srv/subs/{name}.{format}", "../{name}.{format}", "{model}/../../{name}.{format}"} {
+		if _, err := ResolveOutputTemplate(tmpl); err == nil {
+			t.Errorf("ResolveOutputTemplate(%q) accepted a path outside the output directory", tmpl)
+		}
+	}
+	if _, err := ResolveOutputTemplate("{model}/../{name}.{format}"); err != nil {
+		t.Errorf("template staying inside the directory rejected: %v", err)
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	n := OutputName{Name: "movie", Model: "tiny", Format: "srt"}
+	if got, err := OutputPath("/media", "{model}/{name}.{format}", n, LangStyleISO1); err != nil || got != filepath.Join("/media", "tiny", "movie.srt") {
+		t.Errorf("OutputPath = %q, %v", got, err)
+	}
+	if got, err := OutputPath("/media", "{model}/../{name}.{format}", n, LangStyleISO1); err != nil || got != filepath.Join("/media", "movie.srt") {
+		t.Errorf("OutputPath = %q, %v", got, err)
+	}
+	n.Model = ".."
+	if _, err := OutputPath("/media", "{model}/{name}.{format}", n, LangStyleISO1); err == nil {
+		t.Error("OutputPath accepted a name outside the directory")
+	}
+}
+
+func TestTemplateUsesLanguage(t *testing.T) {
+	if !TemplateUsesLanguage(outputPresets["jellyfin"]) {
+		t.Error("jellyfin preset uses a language token")
+	}
+	if TemplateUsesLanguage(defaultSingleTrackTemplate) {
+		t.Error("default single-track template has no language token")
+	}
+}
+
+// newTestPlanner returns an OutputPlanner whose view of the disk is the
+// given set of existing paths.
+func newTestPlanner(policy string, existing ...string) *OutputPlanner {
+	p := NewOutputPlanner(policy)
+	onDisk := map[string]bool{}
+	for _, e := range existing {
+		onDisk[e] = true
+	}
+	p.exists = func(path string) bool { return onDisk[path] }
+	return p
+}
+
+func TestOutputPlanner_InRunCollision(t *testing.T) {
+	p := newTestPlanner(OverwriteAlways)
+
+	first, ok := p.Claim("out/movie.eng.srt", "Main", 1)
+	if !ok || first != "out/movie.eng.srt" {
+		t.Fatalf("first claim = %q, %v", first, ok)
+	}
+	second, ok := p.Claim("out/movie.eng.srt", "Director's Commentary", 2)
+	if !ok || second != "out/movie.eng.directors-commentary.srt" {
+		t.Errorf("second claim = %q, %v; want title-disambiguated name", second, ok)
+	}
+	third, ok := p.Claim("out/movie.eng.srt", "", 3)
+	if !ok || third != "out/movie.eng.track3.srt" {
+		t.Errorf("untitled claim = %q, %v; want track-disambiguated name", third, ok)
+	}
+}
+
+func TestOutputPlanner_SameTitleFallsBackToTrack(t *testing.T) {
+	p := newTestPlanner(OverwriteAlways)
+	p.Claim("movie.und.srt", "Stereo", 1)
+	p.Claim("movie.und.srt", "Stereo", 2)
+	got, _ := p.Claim("movie.und.srt", "Stereo", 3)
+	if got != "movie.und.track3.srt" {
+		t.Errorf("got %q; want %q", got, "movie.und.track3.srt")
+	}
+}
+
+func TestOutputPlanner_AlwaysOverwritesDisk(t *testing.T) {
+	p := newTestPlanner(OverwriteAlways, "movie.srt")
+	got, ok := p.Claim("movie.srt", "", 1)
+	if !ok || got != "movie.srt" {
+		t.Errorf("got %q, %v; want movie.srt, true", got, ok)
+	}
+}
+
+func TestOutputPlanner_NeverSkipsExisting(t *testing.T) {
+	p := newTestPlanner(OverwriteNever, "movie.srt")
+	if _, ok := p.Claim("movie.srt", "", 1); ok {
+		t.Error("never policy should skip an existing file")
+	}
+	if got, ok := p.Claim("other.srt", "", 1); !ok || got != "other.srt" {
+		t.Errorf("got %q, %v; want other.srt, true", got, ok)
+	}
+}
+
+func TestOutputPlanner_RenameAvoidsDisk(t *testing.T) {
+	p := newTestPlanner(OverwriteRename, "movie.srt", "movie.track1.srt")
+	got, ok := p.Claim("movie.srt", "", 1)
+	if !ok || got != "movie.track1.2.srt" {
+		t.Errorf("got %q, %v; want movie.track1.2.srt, true", got, ok)
+	}
+}
+
+func TestOutputPlanner_ConcurrentClaims(t *testing.T) {
+	p := newTestPlanner(OverwriteAlways)
+	results := make(chan string, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, _ := p.Claim("movie.srt", "", 1)
+			results <- got
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	seen := map[string]bool{}
+	for got := range results {
+		if seen[got] {
+			t.Errorf("%q handed out twice", got)
+		}
+		seen[got] = true
+	}
+}
+
+func TestValidOverwritePolicy(t *testing.T) {
+	for _, p := range []string{OverwriteAlways, OverwriteNever, OverwriteRename} {
+		if !ValidOverwritePolicy(p) {
+			t.Errorf("%q should be valid", p)
+		}
+	}
+	if ValidOverwritePolicy("sometimes") {
+		t.Error("sometimes should not be valid")
+	}
+}
@@ -0,0 +1,901 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the settings that apply to every file of a run, as parsed
+// and validated from the command line.
+type Config struct {
+	Language           string // whisper language code; "" = track tag or auto-detect
+	Model              string
+	Format             string
+	OutputDir          string
+	OutputTemplate     string // resolved template; "" = default naming
+	LangCodes          string
+	Overwrite          string
+	AudioTrack         int
+	Jobs               int           // files transcribed at once
+	Prefetch           int           // tracks decoded ahead of transcription; 0 = no pipelining
+	Chunked            bool          // split each file over all engines instead of running files in parallel
+	Timeout            time.Duration // limit on transcribing one track; 0 = none
+	MaxRTF             float64       // limit as a multiple of the audio duration; 0 = none
+	CodeSwitching      bool
+	TrustTrackLanguage bool    // transcribe in a track's tagged language instead of detecting it
+	Cascade            string  // model that transcribes low-confidence regions again; "" = none
+	CascadeThreshold   float64 // confidence threshold of the cascade
+	Hallucinations     string  // one of the Hallucinations constants; "" = off
+	Review             string  // review report format, ReviewText or ReviewHTML; "" = none
+	ReviewPercent      float64 // share of cues the review report lists
+	Forced             bool
+	SDH                bool
+	Live               bool // print segments as they are transcribed
+	Unattended         bool // never ask (watch mode); files with several tracks need --audio-track
+	Verbose            bool
+}
+
+// probeTracks and extractAudio read the media files, and menuInput holds
+// the answers to the track menu. Tests replace them to exercise the
+// processing loops without real media.
+var (
+	probeTracks            = ProbeAudioTracks
+	extractAudio           = ExtractAudio
+	menuInput    io.Reader = os.Stdin
+)
+
+// TranscribeOptions are the optional settings of a transcription.
+type TranscribeOptions struct {
+	// Language is an ISO-639-1 code (e.g. "en", "de"), or "" for
+	// auto-detection.
+	Language string
+
+	// Temperature is the initial sampling temperature; 0 decodes greedily,
+	// falling back to higher temperatures only where decoding fails.
+	Temperature float64
+
+	// OnProgress, if non-nil, is called with the percentage [0..100].
+	OnProgress func(int)
+
+	// OnSegment, if non-nil, is called with each segment as soon as it is
+	// decoded, with the same timestamps as the returned segments.
+	OnSegment func(Segment)
+}
+
+// Transcriber is the transcription API shared by WhisperModel and
+// WhisperState.
+type Transcriber interface {
+	Transcribe(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error)
+	DetectLanguage(ctx context.Context, samples []float32) string
+}
+
+// Processor transcribes media files according to a Config. It keeps the
+// model loaded between files, so a long-running caller (such as watch mode)
+// only pays the model loading cost once.
+type Processor struct {
+	cfg      Config
+	engines  []Transcriber // one per worker
+	refiners []Transcriber // the --cascade model, one per worker; nil = no cascade
+	planner  *OutputPlanner
+	partials *PartialFiles // outputs being written, for signal cleanup
+
+	failMu      sync.Mutex
+	failed      []string // "file: error" for the batch summary
+	failedFiles []string // paths of the files that failed in the batch
+
+	// muted is set while C output is suppressed for a whole batch, which
+	// is needed whenever decoding or transcription runs in the background:
+	// the redirection is process-wide. Messages then go to realStdout.
+	muted bool
+}
+
+// NewProcessor creates a Processor that runs one worker per engine. With a
+// single engine files are processed in order with a live progress bar;
+// with several, the engines must be independent (separate WhisperStates).
+// In chunked mode files are processed in order and each is split over all
+// the engines.
+func NewProcessor(cfg Config, engines []Transcriber, partials *PartialFiles) *Processor {
+	return &Processor{cfg: cfg, engines: engines, partials: partials}
+}
+
+// SetRefiners gives each worker, in the order of the engines, an engine of
+// the --cascade model to transcribe low-confidence regions again with.
+func (p *Processor) SetRefiners(refiners []Transcriber) {
+	p.refiners = refiners
+}
+
+// refiner returns the --cascade engine of worker i, or nil.
+func (p *Processor) refiner(i int) Transcriber {
+	if i < len(p.refiners) {
+		return p.refiners[i]
+	}
+	return nil
+}
+
+// fileJob is one file to process, along with the engine and I/O of the
+// worker handling it.
+type fileJob struct {
+	index, total int // for the "[n/total]" header
+	file         MediaFile
+	engine       Transcriber
+	refiner      Transcriber // the --cascade model; nil = none
+	out, errOut  io.Writer
+	in           io.Reader // answers to the track menu; nil = defer the file if it needs one
+	streams      []int     // audio tracks picked before the job was handed out; nil = pick when planning
+	progress     bool      // show a progress bar
+}
+
+// filePlan is a file with the audio tracks chosen for transcription.
+type filePlan struct {
+	job    fileJob
+	tracks []*trackPlan
+}
+
+// trackPlan is one audio track to transcribe, with everything decided
+// before its audio is decoded.
+type trackPlan struct {
+	cfg    Config
+	tracks []AudioTrack
+	stream int
+	tmpl   string
+
+	naming          OutputName
+	outDir          string
+	outPath         string
+	languagePending bool // outPath is claimed once the language is detected
+	tagLang         string
+	transcribeLang  string
+
+	samples []float32
+	err     error
+	ready   chan struct{} // closed once decoded in the background; nil = decode inline
+}
+
+// ProcessFiles transcribes a batch of files. Output paths are claimed
+// through a planner shared by the batch, so that two tracks never write to
+// the same file. Cancelling ctx stops the work in progress and skips the
+// remaining files.
+func (p *Processor) ProcessFiles(ctx context.Context, files []MediaFile) {
+	p.planner = NewOutputPlanner(p.cfg.Overwrite)
+	p.failed, p.failedFiles = nil, nil
+	defer p.printFailures(ctx)
+
+	switch {
+	case len(p.engines) > 1 && !p.cfg.Chunked:
+		p.withMutedOutput(func() { p.processParallel(ctx, files) })
+	case p.cfg.Prefetch > 0:
+		p.withMutedOutput(func() { p.processPipelined(ctx, files) })
+	default:
+		for i, mf := range files {
+			if ctx.Err() != nil {
+				return
+			}
+			plan, _ := p.planFile(ctx, fileJob{
+				index: i + 1, total: len(files), file: mf, engine: p.engines[0], refiner: p.refiner(0),
+				out: os.Stdout, errOut: os.Stderr, in: p.stdin(), progress: true,
+			})
+			for _, tp := range plan.tracks {
+				p.finishTrack(ctx, plan.job, tp)
+			}
+		}
+	}
+}
+
+// stdin returns where the track menu reads its answer from: nothing when
+// running unattended, so the menu fails with a hint instead of waiting.
+func (p *Processor) stdin() io.Reader {
+	if p.cfg.Unattended {
+		return strings.NewReader("")
+	}
+	return os.Stdin
+}
+
+// fail reports an error for the job's file and records it for the summary
+// printed at the end of the batch.
+func (p *Processor) fail(job fileJob, format string, a ...any) {
+	p.record(job, true, fmt.Sprintf(format, a...))
+}
+
+// failPlanning is fail for errors found while planning a file, such as an
+// unreadable container or an unanswered track menu. They recur until the
+// file or the options change, so FailedFiles leaves the file out.
+func (p *Processor) failPlanning(job fileJob, format string, a ...any) {
+	p.record(job, false, fmt.Sprintf(format, a...))
+}
+
+func (p *Processor) record(job fileJob, retry bool, msg string) {
+	fmt.Fprintf(job.errOut, "  %s\n", msg)
+	p.failMu.Lock()
+	defer p.failMu.Unlock()
+	p.failed = append(p.failed, filepath.Base(job.file.Path)+": "+msg)
+	if retry && !slices.Contains(p.failedFiles, job.file.Path) {
+		p.failedFiles = append(p.failedFiles, job.file.Path)
+	}
+}
+
+// FailedFiles returns the paths of the files that failed in the last
+// batch in a way that trying again may fix.
+func (p *Processor) FailedFiles() []string {
+	p.failMu.Lock()
+	defer p.failMu.Unlock()
+	return slices.Clone(p.failedFiles)
+}
+
+// printFailures lists the files that failed in this batch, unless the
+// batch was interrupted.
+func (p *Processor) printFailures(ctx context.Context) {
+	if len(p.failed) == 0 || ctx.Err() != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d failed:\n", len(p.failed))
+	for _, f := range p.failed {
+		fmt.Fprintf(os.Stderr, "  %s\n", f)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// withMutedOutput runs fn with C output suppressed throughout (unless
+// --verbose is set) and p.muted set.
+func (p *Processor) withMutedOutput(fn func()) {
+	if !p.cfg.Verbose {
+		restore := suppressCOutput()
+		defer restore()
+	}
+	p.muted = true
+	defer func() { p.muted = false }()
+	fn()
+}
+
+// processParallel spreads files over the workers, largest first so that
+// long files do not end up running alone at the end of the batch. Each
+// file's messages are buffered and printed as one block when it finishes.
+func (p *Processor) processParallel(ctx context.Context, files []MediaFile) {
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	var printMu sync.Mutex
+	// Workers cannot prompt, so files with several tracks are asked about
+	// first. If that fails, the empty stdin below makes the worker fail the
+	// file with a hint to use --audio-track.
+	picks := p.pickTracksAhead(ctx, files)
+	for w, engine := range p.engines {
+		wg.Add(1)
+		go func(engine, refiner Transcriber) {
+			defer wg.Done()
+			for i := range queue {
+				var out, errOut bytes.Buffer
+				plan, _ := p.planFile(ctx, fileJob{
+					index: i + 1, total: len(files), file: files[i], engine: engine, refiner: refiner,
+					out: &out, errOut: &errOut, in: strings.NewReader(""), streams: picks[i],
+				})
+				for _, tp := range plan.tracks {
+					p.finishTrack(ctx, plan.job, tp)
+				}
+				printMu.Lock()
+				realStdout.Write(out.Bytes())
+				realStderr.Write(errOut.Bytes())
+				printMu.Unlock()
+			}
+		}(engine, p.refiner(w))
+	}
+	for _, i := range scheduleBySize(files) {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// pickTracksAhead shows the track menu for each file with several audio
+// tracks and no --audio-track, before any work starts, and returns the
+// choices by file index.
+func (p *Processor) pickTracksAhead(ctx context.Context, files []MediaFile) map[int][]int {
+	picks := map[int][]int{}
+	if p.cfg.Unattended {
+		return picks
+	}
+	for i, mf := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		if p.fileConfig(mf).AudioTrack >= 0 {
+			continue
+		}
+		tracks, err := probeTracks(mf.Path)
+		if err != nil || len(tracks) < 2 {
+			continue
+		}
+		fmt.Fprintf(realStdout, "[%d/%d] %s\n", i+1, len(files), filepath.Base(mf.Path))
+		if streams, err := PickAudioTracksFrom(ctx, tracks, -1, menuInput, realStdout); err == nil {
+			picks[i] = streams
+		}
+	}
+	return picks
+}
+
+// processPipelined processes files in order while decoding audio ahead in
+// the background, so slow storage does not leave the model idle. At most
+// Prefetch decoded tracks wait in memory besides the one being transcribed.
+//
+// The next file is planned (probed, tracks picked, output claimed) while
+// the current one is transcribed, with its messages held back until its
+// turn. A file that needs the interactive track menu is not planned ahead;
+// it is handled when its turn comes, without overlap.
+func (p *Processor) processPipelined(ctx context.Context, files []MediaFile) {
+	slots := make(chan struct{}, p.cfg.Prefetch+1)
+	var last chan struct{} // ready channel of the most recently queued track
+
+	// Background decoders stop on cancellation; wait for them, so nothing
+	// runs once the batch has returned.
+	var decoders sync.WaitGroup
+	defer decoders.Wait()
+
+	// queue starts decoding the plan's tracks in the background. Tracks are
+	// decoded one at a time, in order, each once a buffer slot is free.
+	queue := func(plan *filePlan) {
+		path := plan.job.file.Path
+		for _, tp := range plan.tracks {
+			prev := last
+			tp.ready = make(chan struct{})
+			last = tp.ready
+			decoders.Add(1)
+			go func(tp *trackPlan) {
+				defer decoders.Done()
+				if prev != nil {
+					<-prev
+				}
+				select {
+				case slots <- struct{}{}:
+					p.decode(ctx, path, tp)
+				case <-ctx.Done():
+					tp.err = ctx.Err()
+					close(tp.ready)
+				}
+			}(tp)
+		}
+	}
+
+	var aheadOut, aheadErr *bytes.Buffer // held-back messages of the planned-ahead file
+	var next *filePlan
+	for i, mf := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		plan := next
+		next = nil
+		if plan == nil {
+			plan, _ = p.planFile(ctx, fileJob{
+				index: i + 1, total: len(files), file: mf, engine: p.engines[0], refiner: p.refiner(0),
+				out: realStdout, errOut: realStderr, in: p.stdin(), progress: true,
+			})
+			queue(plan)
+		} else {
+			realStdout.Write(aheadOut.Bytes())
+			realStderr.Write(aheadErr.Bytes())
+			plan.job.out, plan.job.errOut, plan.job.progress = realStdout, realStderr, true
+		}
+
+		// Plan the next file now so its audio decodes while this one is
+		// transcribed.
+		if i+1 < len(files) && ctx.Err() == nil {
+			aheadOut, aheadErr = &bytes.Buffer{}, &bytes.Buffer{}
+			n, deferred := p.planFile(ctx, fileJob{
+				index: i + 2, total: len(files), file: files[i+1], engine: p.engines[0], refiner: p.refiner(0),
+				out: aheadOut, errOut: aheadErr,
+			})
+			if !deferred {
+				next = n
+				queue(next)
+			}
+		}
+
+		for _, tp := range plan.tracks {
+			p.finishTrack(ctx, plan.job, tp)
+			tp.samples = nil
+			select {
+			case <-slots:
+			default: // cancelled before it was decoded
+			}
+		}
+	}
+}
+
+// scheduleBySize returns the indices of files ordered by decreasing file
+// size, keeping the input order for equal sizes. Size stands in for
+// duration, which is not known until the file is opened.
+func scheduleBySize(files []MediaFile) []int {
+	sizes := make([]int64, len(files))
+	order := make([]int, len(files))
+	for i, f := range files {
+		if info, err := os.Stat(f.Path); err == nil {
+			sizes[i] = info.Size()
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return sizes[order[a]] > sizes[order[b]] })
+	return order
+}
+
+// quiet runs fn with C stdout/stderr suppressed unless --verbose is set or
+// the output is already muted for the whole batch.
+func (p *Processor) quiet(fn func()) {
+	if p.cfg.Verbose || p.muted {
+		fn()
+		return
+	}
+	restore := suppressCOutput()
+	defer restore()
+	fn()
+}
+
+// fileConfig returns the configuration for one file: per-file options
+// from an input list override the global flags.
+func (p *Processor) fileConfig(mf MediaFile) Config {
+	cfg := p.cfg
+	if o := mf.Options; o != nil {
+		if o.Language != "" {
+			cfg.Language, cfg.CodeSwitching = o.Language, false
+		}
+		if o.AudioTrack >= 0 {
+			cfg.AudioTrack = o.AudioTrack
+		}
+	}
+	return cfg
+}
+
+// planFile probes a file and plans the transcription of its selected audio
+// track(s). Errors are reported on the job's error stream and leave the
+// plan without tracks. If the file needs the interactive track menu and
+// job.in is nil, nothing is printed and deferred is true.
+func (p *Processor) planFile(ctx context.Context, job fileJob) (plan *filePlan, deferred bool) {
+	plan = &filePlan{job: job}
+	file := job.file.Path
+
+	fileCfg := p.fileConfig(job.file)
+
+	// Probe audio tracks.
+	tracks, err := probeTracks(file)
+	if job.in == nil && job.streams == nil && err == nil && len(tracks) > 1 && fileCfg.AudioTrack < 0 {
+		return plan, true
+	}
+	fmt.Fprintf(job.out, "[%d/%d] %s\n", job.index, job.total, filepath.Base(file))
+	if err != nil {
+		p.failPlanning(job, "Error probing audio: %v", err)
+		return plan, false
+	}
+
+	// Pick audio track(s), unless that was done before.
+	streamIndices := job.streams
+	for _, s := range streamIndices {
+		fmt.Fprintf(job.out, "  Using audio track %d (selected)\n", s)
+	}
+	if streamIndices == nil {
+		streamIndices, err = PickAudioTracksFrom(ctx, tracks, fileCfg.AudioTrack, job.in, job.out)
+		if ctx.Err() != nil {
+			return plan, false
+		}
+		if err != nil {
+			p.failPlanning(job, "%v, skipping", err)
+			return plan, false
+		}
+	}
+
+	tmpl := fileCfg.OutputTemplate
+	if tmpl == "" {
+		tmpl = defaultSingleTrackTemplate
+		if len(streamIndices) > 1 {
+			tmpl = defaultMultiTrackTemplate
+		}
+	}
+
+	for _, streamIdx := range streamIndices {
+		if tp := p.planTrack(job, fileCfg, tracks, streamIdx, tmpl); tp != nil {
+			plan.tracks = append(plan.tracks, tp)
+		}
+	}
+	return plan, false
+}
+
+// planTrack decides the language and output path of one audio track. It
+// returns nil if the track is skipped.
+func (p *Processor) planTrack(job fileJob, cfg Config, tracks []AudioTrack, streamIdx int, tmpl string) *trackPlan {
+	file := job.file.Path
+	tp := &trackPlan{cfg: cfg, tracks: tracks, stream: streamIdx, tmpl: tmpl}
+
+	// Language: --language wins, then the container's tag if it is
+	// trusted. Otherwise it is detected after extraction, with the tag
+	// as a fallback; tags are often wrong, e.g. a default "eng".
+	tp.tagLang, _ = WhisperLanguage(TrackLanguage(tracks, streamIdx))
+	tp.transcribeLang = cfg.Language
+	if tp.transcribeLang == "" && !cfg.CodeSwitching && cfg.TrustTrackLanguage {
+		tp.transcribeLang = tp.tagLang
+	}
+
+	// Determine output path. Files are named after the language that is
+	// detected, if it is; otherwise after the track's own language where
+	// it is tagged, so tracks stay distinguishable under --language.
+	detect := tp.transcribeLang == "" && !cfg.CodeSwitching
+	tp.naming = OutputName{
+		Name:   strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		Track:  streamIdx,
+		Model:  ModelLabel(cfg.Model),
+		Format: cfg.Format,
+		Forced: cfg.Forced,
+		SDH:    cfg.SDH,
+	}
+	if !detect {
+		tp.naming.Lang = tp.tagLang
+		if tp.naming.Lang == "" {
+			tp.naming.Lang = tp.transcribeLang
+		}
+	}
+	// Under --output-dir, the layout below each directory argument
+	// is mirrored.
+	tp.outDir = filepath.Dir(file)
+	if cfg.OutputDir != "" {
+		tp.outDir = filepath.Join(cfg.OutputDir, job.file.RelDir())
+	}
+
+	// When the name depends on a language that is not yet known,
+	// the path is claimed after detection instead.
+	tp.languagePending = detect && TemplateUsesLanguage(tmpl)
+	if !tp.languagePending {
+		outPath, err := OutputPath(tp.outDir, tmpl, tp.naming, cfg.LangCodes)
+		if err != nil {
+			p.failPlanning(job, "%v, skipping", err)
+			return nil
+		}
+		var ok bool
+		if tp.outPath, ok = p.claimOutput(job, tp, outPath); !ok {
+			return nil
+		}
+	}
+	return tp
+}
+
+// claimOutput applies the overwrite policy and in-run collision handling
+// to path, reporting renames and skips.
+func (p *Processor) claimOutput(job fileJob, tp *trackPlan, path string) (string, bool) {
+	claimed, ok := p.planner.Claim(path, TrackTitle(tp.tracks, tp.stream), tp.stream)
+	if !ok {
+		fmt.Fprintln(job.out, "  Skipping (subtitle file exists)")
+		return "", false
+	}
+	if claimed != path {
+		fmt.Fprintf(job.out, "  %s is taken, writing %s instead\n", filepath.Base(path), filepath.Base(claimed))
+	}
+	return claimed, true
+}
+
+// decode extracts the track's audio into tp.samples (or tp.err). In
+// pipelined runs it is called in the background and signals tp.ready.
+func (p *Processor) decode(ctx context.Context, path string, tp *trackPlan) {
+	p.quiet(func() { tp.samples, tp.err = extractAudio(ctx, path, tp.stream) })
+	if tp.ready != nil {
+		close(tp.ready)
+	}
+}
+
+// finishTrack transcribes a planned track and writes its subtitles. If ctx
+// is cancelled, nothing is written.
+func (p *Processor) finishTrack(ctx context.Context, job fileJob, tp *trackPlan) {
+	cfg := tp.cfg
+	file := job.file.Path
+
+	// Extract audio, or wait for the background decoder.
+	fmt.Fprintf(job.out, "  Extracting audio (track %d)...\n", tp.stream)
+	if tp.ready != nil {
+		<-tp.ready
+	} else {
+		p.decode(ctx, file, tp)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(job.errOut, "  Interrupted")
+		return
+	}
+	if tp.err != nil {
+		p.fail(job, "Error extracting audio: %v", tp.err)
+		return
+	}
+	samples := tp.samples
+
+	// Detect language if neither --language nor a trusted track tag gave
+	// one.
+	transcribeLang := tp.transcribeLang
+	if cfg.Language == "" && tp.tagLang != "" && !cfg.CodeSwitching {
+		fmt.Fprintf(job.out, "  Track language: %s\n", LanguageLabel(tp.tagLang))
+	}
+	if transcribeLang == "" && !cfg.CodeSwitching {
+		var detected string
+		p.quiet(func() { detected = job.engine.DetectLanguage(ctx, samples) })
+		if ctx.Err() != nil {
+			fmt.Fprintln(job.errOut, "  Interrupted")
+			return
+		}
+		switch {
+		case detected != "":
+			fmt.Fprintf(job.out, "  Detected language: %s\n", LanguageLabel(detected))
+			if tp.tagLang != "" && detected != tp.tagLang {
+				fmt.Fprintf(job.out, "  Warning: the track is tagged %s but sounds like %s; transcribing as %s\n",
+					LanguageLabel(tp.tagLang), LanguageLabel(detected), LanguageLabel(detected))
+			}
+			transcribeLang = detected
+		case tp.tagLang != "":
+			fmt.Fprintf(job.out, "  Language detection failed, using the track tag\n")
+			transcribeLang = tp.tagLang
+		}
+	}
+	outPath := tp.outPath
+	if tp.languagePending {
+		var ok bool
+		var err error
+		tp.naming.Lang = transcribeLang
+		if outPath, err = OutputPath(tp.outDir, tp.tmpl, tp.naming, cfg.LangCodes); err != nil {
+			p.fail(job, "%v", err)
+			return
+		}
+		if outPath, ok = p.claimOutput(job, tp, outPath); !ok {
+			return
+		}
+	}
+
+	// Track current output for signal cleanup.
+	p.partials.Add(outPath)
+	defer p.partials.Remove(outPath)
+
+	// Continue from the checkpoint of an earlier, interrupted run. Chunked
+	// and code-switching runs decode out of order and are not checkpointed.
+	var ckpt *checkpointer
+	var earlier []Segment
+	var offset time.Duration
+	streaming := !cfg.CodeSwitching && !cfg.Chunked
+	if streaming {
+		ckpt = newCheckpointer(file, tp.stream, cfg.Model, transcribeLang, samplesToDuration(len(samples)))
+	}
+	if ckpt != nil && ckpt.Offset() > 0 {
+		earlier, offset = ckpt.Segments(), ckpt.Offset()
+		fmt.Fprintf(job.out, "  Resuming at %s (%d segments from an earlier run)\n", offset.Truncate(time.Second), len(earlier))
+		samples = samples[int(offset*16000/time.Second):]
+	}
+
+	// Stream segments to <output>.partial as they are decoded, so they can
+	// be followed before the track is done. The complete file is written
+	// separately at the end, which removes the partial one; if the track
+	// is interrupted, times out or fails, it is kept, ended properly (so
+	// JSON stays valid), as far as the transcription got. Streaming is best
+	// effort: errors here only cost the early output.
+	var stream *SubtitleWriter
+	complete := false
+	if streaming && os.MkdirAll(filepath.Dir(outPath), 0755) == nil {
+		partialPath := outPath + ".partial"
+		if f, err := os.Create(partialPath); err == nil {
+			defer func() {
+				if stream != nil {
+					stream.Close()
+				}
+				f.Close()
+				if complete || stream == nil || stream.n == 0 {
+					os.Remove(partialPath)
+				} else {
+					fmt.Fprintf(job.errOut, "  Partial subtitles kept in %s\n", partialPath)
+				}
+			}()
+			if stream, err = NewSubtitleWriter(f, cfg.Format); err == nil {
+				for _, seg := range earlier {
+					stream.Write(seg)
+				}
+			}
+		}
+	}
+
+	// Transcribe with progress.
+	durationSec := float64(len(samples)) / 16000.0
+	if durationSec < 60 {
+		fmt.Fprintf(job.out, "  Transcribing %.0fs of audio...\n", durationSec)
+	} else {
+		fmt.Fprintf(job.out, "  Transcribing %.0f min of audio...\n", durationSec/60.0)
+	}
+
+	// Give up on the track if it takes longer than --timeout or --max-rtf
+	// allow, so one bad file cannot stall the batch.
+	limit := transcribeLimit(cfg.Timeout, cfg.MaxRTF, len(samples))
+	tctx, cancel := ctx, context.CancelFunc(func() {})
+	if limit > 0 {
+		tctx, cancel = context.WithTimeout(ctx, limit)
+	}
+	defer cancel()
+
+	start := time.Now()
+	var onProgress func(int)
+	var progress *ProgressReporter
+	if job.progress {
+		progress = NewProgressReporter(realStderr)
+		onProgress = progress.Update
+	}
+	var segments []Segment
+	var err error
+	p.quiet(func() {
+		switch {
+		case cfg.CodeSwitching:
+			segments, err = TranscribeCodeSwitching(tctx, job.engine, samples, onProgress)
+		case cfg.Chunked:
+			segments, err = TranscribeChunked(tctx, p.engines, samples, transcribeLang, onProgress)
+		default:
+			onSegment := func(seg Segment) {
+				if ckpt != nil {
+					ckpt.Add(seg)
+				}
+				seg.Start += offset
+				seg.End += offset
+				if stream != nil {
+					stream.Write(seg)
+				}
+				if cfg.Live && job.progress {
+					if progress != nil {
+						progress.Finish()
+					}
+					fmt.Fprintf(realStdout, "  [%s --> %s] %s\n", FormatTimestamp(seg.Start, "vtt"), FormatTimestamp(seg.End, "vtt"), strings.TrimSpace(seg.Text))
+				}
+			}
+			segments, err = job.engine.Transcribe(tctx, samples, TranscribeOptions{Language: transcribeLang, OnProgress: onProgress, OnSegment: onSegment})
+		}
+	})
+	if progress != nil {
+		progress.Finish()
+	}
+	if cfg.CodeSwitching && err == nil {
+		fmt.Fprintf(job.out, "  Detected languages: %s\n", strings.Join(SegmentLanguages(segments), ", "))
+	}
+
+	if ctx.Err() != nil {
+		if ckpt != nil && len(ckpt.Segments()) > 0 && ckpt.Save() == nil {
+			fmt.Fprintln(job.errOut, "  Interrupted (progress saved; rerun to resume)")
+		} else {
+			fmt.Fprintln(job.errOut, "  Interrupted")
+		}
+		return
+	}
+	if tctx.Err() != nil {
+		p.fail(job, "Timed out after %s, skipping", limit)
+		return
+	}
+	if err != nil {
+		p.fail(job, "Error transcribing: %v", err)
+		return
+	}
+	if len(earlier) > 0 {
+		for i := range segments {
+			segments[i].Start += offset
+			segments[i].End += offset
+		}
+		segments = append(earlier[:len(earlier):len(earlier)], segments...)
+	}
+
+	// Transcribe the doubtful regions again with the --cascade model.
+	if job.refiner != nil {
+		var tried, replaced int
+		p.quiet(func() {
+			segments, tried, replaced, err = RefineSegments(tctx, job.refiner, tp.samples, segments, transcribeLang, cfg.CascadeThreshold)
+		})
+		if ctx.Err() != nil {
+			fmt.Fprintln(job.errOut, "  Interrupted")
+			return
+		}
+		if tctx.Err() != nil {
+			p.fail(job, "Timed out after %s, skipping", limit)
+			return
+		}
+		if tried > 0 {
+			fmt.Fprintf(job.out, "  Cascade: %d of %d low-confidence region(s) improved by model '%s'\n", replaced, tried, cfg.Cascade)
+		}
+	}
+
+	// Deal with repetition loops and other hallucinations.
+	var found []Hallucination
+	if cfg.Hallucinations != "" && cfg.Hallucinations != HallucinationsOff {
+		p.quiet(func() {
+			segments, found, err = HandleHallucinations(tctx, job.engine, tp.samples, segments, transcribeLang, cfg.Hallucinations)
+		})
+		if ctx.Err() != nil {
+			fmt.Fprintln(job.errOut, "  Interrupted")
+			return
+		}
+		if tctx.Err() != nil {
+			p.fail(job, "Timed out after %s, skipping", limit)
+			return
+		}
+		reportHallucinations(job.out, found)
+	}
+
+	// Write subtitle file. Templates may place it in a subdirectory.
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		p.fail(job, "Error creating output directory: %v", err)
+		return
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		p.fail(job, "Error creating output file: %v", err)
+		return
+	}
+
+	switch cfg.Format {
+	case "vtt":
+		err = WriteVTT(f, segments)
+	case "ttml":
+		err = WriteTTML(f, segments)
+	case "json":
+		err = WriteJSON(f, segments)
+	default:
+		err = WriteSRT(f, segments)
+	}
+	f.Close()
+
+	if err != nil {
+		p.fail(job, "Error writing subtitles: %v", err)
+		os.Remove(outPath)
+		return
+	}
+	complete = true
+
+	if ckpt != nil {
+		ckpt.Remove()
+	}
+
+	elapsed := time.Since(start)
+	em := int(elapsed.Seconds()) / 60
+	es := int(elapsed.Seconds()) % 60
+	fmt.Fprintf(job.out, "  Done: %d segments in %dm%02ds -> %s\n", len(segments), em, es, outPath)
+	if cfg.Review != "" {
+		writeReview(job, cfg, outPath, segments, found)
+	}
+	fmt.Fprintln(job.out)
+}
+
+// writeReview writes the --review report for the subtitle file at subPath.
+// The subtitles are done by then, so failing only prints a warning.
+func writeReview(job fileJob, cfg Config, subPath string, segments []Segment, found []Hallucination) {
+	path := reviewPath(subPath, cfg.Review)
+	cues := SelectReview(segments, cfg.ReviewPercent, found)
+	f, err := os.Create(path)
+	if err == nil {
+		err = WriteReview(f, cfg.Review, filepath.Base(subPath), cfg.Format, cues, len(segments))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(job.errOut, "  Warning: could not write review report: %v\n", err)
+		os.Remove(path)
+		return
+	}
+	fmt.Fprintf(job.out, "  Review: %d cue(s) to check -> %s\n", len(cues), path)
+}
+
+// minTranscribeLimit is the least time --max-rtf allows for a track, so
+// short clips are not cut off by fixed start-up costs.
+const minTranscribeLimit = time.Minute
+
+// transcribeLimit returns how long transcribing n samples may take given
+// --timeout and --max-rtf (the lower of the two applies), or 0 for no
+// limit.
+func transcribeLimit(timeout time.Duration, maxRTF float64, n int) time.Duration {
+	limit := timeout
+	if maxRTF > 0 {
+		rtfLimit := time.Duration(maxRTF * float64(samplesToDuration(n)))
+		rtfLimit = max(rtfLimit, minTranscribeLimit)
+		if limit == 0 || rtfLimit < limit {
+			limit = rtfLimit
+		}
+	}
+	return limit
+}
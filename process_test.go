@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduleBySize_LargestFirst(t *testing.T) {
+	dir := t.TempDir()
+	var files []MediaFile
+	for _, f := range []struct {
+		name string
+		size int
+	}{{"a.mkv", 10}, {"b.mkv", 300}, {"c.mkv", 10}, {"d.mkv", 50}} {
+		path := filepath.Join(dir, f.name)
+		os.WriteFile(path, make([]byte, f.size), 0644)
+		files = append(files, MediaFile{Path: path})
+	}
+	files = append(files, MediaFile{Path: filepath.Join(dir, "missing.mkv")})
+
+	got := scheduleBySize(files)
+	want := []int{1, 3, 0, 2, 4} // equal sizes keep input order; missing files last
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scheduleBySize = %v; want %v", got, want)
+	}
+}
+
+// fakeTranscriber is a Transcriber that calls the test's functions. Left
+// nil, transcribe returns one "hello" segment and detect finds English.
+type fakeTranscriber struct {
+	transcribe func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error)
+	detect     func(ctx context.Context, samples []float32) string
+}
+
+func (f fakeTranscriber) Transcribe(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+	if f.transcribe == nil {
+		return []Segment{{Start: 0, End: time.Second, Text: "hello"}}, nil
+	}
+	return f.transcribe(ctx, samples, opts)
+}
+
+func (f fakeTranscriber) DetectLanguage(ctx context.Context, samples []float32) string {
+	if f.detect == nil {
+		return "en"
+	}
+	return f.detect(ctx, samples)
+}
+
+// languageTranscriber returns a fakeTranscriber that records the language
+// each transcription was asked for.
+func languageTranscriber(langs *[]string) fakeTranscriber {
+	return fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		*langs = append(*langs, opts.Language)
+		return []Segment{{Start: 0, End: time.Second, Text: "hello"}}, nil
+	}}
+}
+
+// interruptedTranscriber returns a fakeTranscriber that decodes two
+// segments and is then interrupted, as if the user pressed Ctrl-C.
+func interruptedTranscriber(cancel context.CancelFunc) fakeTranscriber {
+	return fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		opts.OnSegment(Segment{Start: 0, End: 2 * time.Second, Text: "first"})
+		opts.OnSegment(Segment{Start: 2 * time.Second, End: 4 * time.Second, Text: "second"})
+		cancel()
+		return nil, ctx.Err()
+	}}
+}
+
+// stubMedia makes the processing loops see media files with the given
+// audio tracks, each decoding to a second of silence, keeps their
+// checkpoints in a temporary directory and mutes their output. Everything
+// is restored when the test ends.
+func stubMedia(t *testing.T, tracks ...AudioTrack) {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, extract, in, checkpoints := probeTracks, extractAudio, menuInput, checkpointDirOverride
+	stdout, stderr, realOut, realErr := os.Stdout, os.Stderr, realStdout, realStderr
+	t.Cleanup(func() {
+		probeTracks, extractAudio, menuInput, checkpointDirOverride = probe, extract, in, checkpoints
+		os.Stdout, os.Stderr, realStdout, realStderr = stdout, stderr, realOut, realErr
+		devNull.Close()
+	})
+
+	probeTracks = func(string) ([]AudioTrack, error) { return tracks, nil }
+	extractAudio = func(context.Context, string, int) ([]float32, error) {
+		return make([]float32, 16000), nil
+	}
+	checkpointDirOverride = t.TempDir()
+	os.Stdout, os.Stderr, realStdout, realStderr = devNull, devNull, devNull, devNull
+}
+
+func TestProcessPipelined(t *testing.T) {
+	dir := t.TempDir()
+	var files []MediaFile
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, nil, 0644)
+		files = append(files, MediaFile{Path: path})
+	}
+
+	var mu sync.Mutex
+	var decoded atomic.Int64
+	var order []string
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"})
+	extractAudio = func(ctx context.Context, path string, stream int) ([]float32, error) {
+		decoded.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, filepath.Base(path))
+		return make([]float32, 16000), nil
+	}
+
+	// Each call records how many decoded tracks were held in memory.
+	var calls, maxHeld int
+	fake := fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		maxHeld = max(maxHeld, int(decoded.Load())-calls)
+		calls++
+		time.Sleep(5 * time.Millisecond) // give the decoder a chance to run ahead
+		return []Segment{{Start: 0, End: time.Second, Text: "hello"}}, nil
+	}}
+	cfg := Config{Format: "srt", Overwrite: OverwriteAlways, AudioTrack: -1, Prefetch: 1, Verbose: true}
+	p := NewProcessor(cfg, []Transcriber{fake}, &PartialFiles{})
+	p.ProcessFiles(context.Background(), files)
+
+	if want := []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("decode order = %v; want %v", order, want)
+	}
+	if maxHeld > cfg.Prefetch+1 {
+		t.Errorf("%d decoded tracks held at once; want at most %d", maxHeld, cfg.Prefetch+1)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(strings.TrimSuffix(f.Path, ".mkv") + ".srt"); err != nil {
+			t.Errorf("missing output for %s: %v", filepath.Base(f.Path), err)
+		}
+	}
+}
+
+func TestTranscribeLimit(t *testing.T) {
+	hour := 60 * 60 * 16000
+	tests := []struct {
+		timeout time.Duration
+		maxRTF  float64
+		samples int
+		want    time.Duration
+	}{
+		{0, 0, hour, 0},
+		{2 * time.Hour, 0, hour, 2 * time.Hour},
+		{0, 1.5, hour, 90 * time.Minute},
+		{time.Hour, 1.5, hour, time.Hour}, // the lower limit wins
+		{0, 0.5, 10 * 16000, time.Minute}, // short clips get a minimum
+		{30 * time.Second, 0.5, 10 * 16000, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := transcribeLimit(tt.timeout, tt.maxRTF, tt.samples); got != tt.want {
+			t.Errorf("transcribeLimit(%v, %v, %d) = %v; want %v", tt.timeout, tt.maxRTF, tt.samples, got, tt.want)
+		}
+	}
+}
+
+func TestProcessFiles_TimeoutContinuesBatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stuck.mkv")
+	os.WriteFile(path, nil, 0644)
+
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"})
+
+	cfg := Config{Format: "srt", Overwrite: OverwriteAlways, AudioTrack: -1, Timeout: 20 * time.Millisecond, Verbose: true}
+	// The engine never finishes until its context is done, like whisper
+	// looping on a corrupted file.
+	stuck := fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	p := NewProcessor(cfg, []Transcriber{stuck}, &PartialFiles{})
+	done := make(chan struct{})
+	go func() {
+		p.ProcessFiles(context.Background(), []MediaFile{{Path: path}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ProcessFiles did not give up on the stuck file")
+	}
+
+	if len(p.failed) != 1 || !strings.Contains(p.failed[0], "Timed out") {
+		t.Errorf("failures = %q; want one timeout", p.failed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stuck.srt")); err == nil {
+		t.Error("a subtitle file was written for the timed-out file")
+	}
+}
+
+func TestProcessFiles_StreamsSegments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "talk.mkv")
+	os.WriteFile(path, nil, 0644)
+
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"})
+
+	out := filepath.Join(dir, "talk.srt")
+	var seen string
+	cfg := Config{Format: "srt", Overwrite: OverwriteAlways, AudioTrack: -1, Verbose: true}
+	// The engine decodes two segments and records what the streamed
+	// partial file contained by then.
+	peeking := fakeTranscriber{transcribe: func(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+		segs := []Segment{
+			{Start: 0, End: time.Second, Text: "one"},
+			{Start: time.Second, End: 2 * time.Second, Text: "two"},
+		}
+		for _, seg := range segs {
+			opts.OnSegment(seg)
+		}
+		data, _ := os.ReadFile(out + ".partial")
+		seen = string(data)
+		return segs, nil
+	}}
+	p := NewProcessor(cfg, []Transcriber{peeking}, &PartialFiles{})
+	p.ProcessFiles(context.Background(), []MediaFile{{Path: path}})
+
+	want := "1\n00:00:00,000 --> 00:00:01,000\none\n\n2\n00:00:01,000 --> 00:00:02,000\ntwo\n\n"
+	if seen != want {
+		t.Errorf("partial file during transcription:\n%q\nwant\n%q", seen, want)
+	}
+	if data, _ := os.ReadFile(out); string(data) != want {
+		t.Errorf("output file:\n%q\nwant\n%q", data, want)
+	}
+	if _, err := os.Stat(out + ".partial"); err == nil {
+		t.Error("partial file left behind after the track finished")
+	}
+}
+
+func TestProcessFiles_TrackTagOnlyTrustedOnRequest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mistagged.mkv")
+	os.WriteFile(path, nil, 0644)
+
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "spa"})
+
+	for _, trust := range []bool{false, true} {
+		var langs []string
+		cfg := Config{Format: "srt", OutputTemplate: "{name}.{lang2}.{format}", Overwrite: OverwriteAlways, AudioTrack: -1, Verbose: true, TrustTrackLanguage: trust}
+		p := NewProcessor(cfg, []Transcriber{languageTranscriber(&langs)}, &PartialFiles{})
+		p.ProcessFiles(context.Background(), []MediaFile{{Path: path}})
+		want := "en" // detected, despite the tag
+		if trust {
+			want = "es"
+		}
+		if len(langs) != 1 || langs[0] != want {
+			t.Errorf("trust %v: transcribed as %v; want %s", trust, langs, want)
+		}
+		// The file is named after the language it was transcribed in.
+		out := filepath.Join(dir, "mistagged."+want+".srt")
+		if _, err := os.Stat(out); err != nil {
+			t.Errorf("trust %v: %v", trust, err)
+		}
+		os.Remove(out)
+	}
+}
+
+func TestProcessParallel_PicksTracksFirst(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dual.mkv")
+	os.WriteFile(path, nil, 0644)
+
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"}, AudioTrack{StreamIndex: 2, Language: "spa"})
+	var mu sync.Mutex
+	var extracted []int
+	extractAudio = func(ctx context.Context, path string, stream int) ([]float32, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		extracted = append(extracted, stream)
+		return make([]float32, 16000), nil
+	}
+	menuInput = strings.NewReader("2\n")
+
+	var langs []string
+	engine := languageTranscriber(&langs)
+	cfg := Config{Format: "srt", Overwrite: OverwriteAlways, AudioTrack: -1, Jobs: 2, Verbose: true}
+	p := NewProcessor(cfg, []Transcriber{engine, engine}, &PartialFiles{})
+	p.ProcessFiles(context.Background(), []MediaFile{{Path: path}})
+
+	if len(p.failed) != 0 || !reflect.DeepEqual(extracted, []int{2}) {
+		t.Errorf("failures %q, extracted tracks %v; want track 2 transcribed", p.failed, extracted)
+	}
+}
+
+func TestProcessFiles_UnattendedNeverPrompts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dual.mkv")
+	os.WriteFile(path, nil, 0644)
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"}, AudioTrack{StreamIndex: 2, Language: "spa"})
+	menuInput = strings.NewReader("2\n")
+
+	var langs []string
+	engine := languageTranscriber(&langs)
+	for _, engines := range [][]Transcriber{{engine}, {engine, engine}} {
+		cfg := Config{Format: "srt", Overwrite: OverwriteAlways, AudioTrack: -1, Jobs: len(engines), Verbose: true, Unattended: true}
+		p := NewProcessor(cfg, engines, &PartialFiles{})
+		p.ProcessFiles(context.Background(), []MediaFile{{Path: path}})
+
+		if len(p.failed) != 1 || !strings.Contains(p.failed[0], "--audio-track") || len(langs) != 0 {
+			t.Errorf("%d job(s): failures %q, %d transcribed; want the file skipped with a hint", len(engines), p.failed, len(langs))
+		}
+		if failed := p.FailedFiles(); len(failed) != 0 {
+			t.Errorf("%d job(s): FailedFiles = %v; want no retry for a file that cannot be planned", len(engines), failed)
+		}
+	}
+}
+
+func TestProcessFiles_KeepsPartialWhenInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "talk.mkv")
+	os.WriteFile(path, nil, 0644)
+	stubMedia(t, AudioTrack{StreamIndex: 1, Language: "eng"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := Config{Format: "json", Overwrite: OverwriteAlways, AudioTrack: -1, Verbose: true}
+	NewProcessor(cfg, []Transcriber{interruptedTranscriber(cancel)}, &PartialFiles{}).ProcessFiles(ctx, []MediaFile{{Path: path}})
+
+	data, err := os.ReadFile(filepath.Join(dir, "talk.json.partial"))
+	if err != nil {
+		t.Fatalf("partial file of the interrupted track: %v", err)
+	}
+	var cues []jsonCue
+	if err := json.Unmarshal(data, &cues); err != nil || len(cues) != 2 {
+		t.Errorf("partial file is not the JSON of the 2 decoded cues (%v):\n%s", err, data)
+	}
+}
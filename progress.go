@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -84,22 +86,75 @@ func formatDuration(secs float64) string {
 	return fmt.Sprintf("%dh%02dm%02ds", h, m, s)
 }
 
-// SignalCleanup registers a handler for SIGINT that removes the given file
-// (if non-empty) before exiting. Call the returned function to deregister
+// PartialFiles is the set of output files currently being written, which
+// must be removed if the run is interrupted. It is safe for concurrent use.
+type PartialFiles struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// Add records path as being written.
+func (p *PartialFiles) Add(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paths == nil {
+		p.paths = map[string]bool{}
+	}
+	p.paths[path] = true
+}
+
+// Remove records that path is complete (or was never created).
+func (p *PartialFiles) Remove(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.paths, path)
+}
+
+// Paths returns the files currently being written, sorted.
+func (p *PartialFiles) Paths() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	paths := make([]string, 0, len(p.paths))
+	for path := range p.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// SignalCleanup registers a handler for SIGINT and SIGTERM. The first
+// signal calls cancel, so the run can stop the current file and clean up
+// on its own; a second one removes the partially written files in partials
+// (if any) and exits immediately. Call the returned function to deregister
 // the handler and stop the goroutine.
-func SignalCleanup(partialPath *string) func() {
+func SignalCleanup(cancel context.CancelFunc, partials *PartialFiles) func() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 
 	done := make(chan struct{})
 	go func() {
+		// Write to realStderr: fd 2 may be muted while whisper runs.
 		select {
 		case <-ch:
-			if partialPath != nil && *partialPath != "" {
-				os.Remove(*partialPath)
-				fmt.Fprintf(os.Stderr, "\nInterrupted, removed partial file: %s\n", *partialPath)
-			} else {
-				fmt.Fprintf(os.Stderr, "\nInterrupted.\n")
+			fmt.Fprintf(realStderr, "\nInterrupting after cleanup... (press Ctrl-C again to quit now)\n")
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-ch:
+			var paths []string
+			if partials != nil {
+				paths = partials.Paths()
+			}
+			fmt.Fprintln(realStderr)
+			if len(paths) == 0 {
+				fmt.Fprintf(realStderr, "Interrupted.\n")
+			}
+			for _, path := range paths {
+				os.Remove(path)
+				fmt.Fprintf(realStderr, "Interrupted, removed partial file: %s\n", path)
 			}
 			os.Exit(1)
 		case <-done:
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Review report formats for --review.
+const (
+	ReviewText = "txt"
+	ReviewHTML = "html"
+)
+
+// defaultReviewPercent is the share of cues --review lists by default.
+const defaultReviewPercent = 5
+
+// ValidReviewFormat reports whether format is a --review format.
+func ValidReviewFormat(format string) bool {
+	return format == ReviewText || format == ReviewHTML
+}
+
+// ReviewCue is a cue an editor should check.
+type ReviewCue struct {
+	Index   int // cue number in the subtitle file, from 1
+	Segment Segment
+	Note    string // why it is listed besides its confidence, e.g. "repeated"; "" = none
+}
+
+// SelectReview returns the cues of segs to review, in file order: the
+// percent with the lowest confidence, at least one, and every segment
+// --hallucinations report kept (found with Action "kept"). Segments with
+// unknown confidence are not ranked.
+func SelectReview(segs []Segment, percent float64, found []Hallucination) []ReviewCue {
+	notes := map[int]string{}
+	for _, h := range found {
+		if h.Action != "kept" {
+			continue
+		}
+		for i, seg := range segs {
+			if seg.Start == h.Segment.Start && seg.Text == h.Segment.Text {
+				notes[i] = h.Reason
+				break
+			}
+		}
+	}
+
+	var ranked []int
+	for i, seg := range segs {
+		if seg.Confidence > 0 && strings.TrimSpace(seg.Text) != "" {
+			ranked = append(ranked, i)
+		}
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return segs[ranked[a]].Confidence < segs[ranked[b]].Confidence
+	})
+	n := int(math.Ceil(float64(len(ranked)) * percent / 100))
+	picked := map[int]bool{}
+	for _, i := range ranked[:min(max(n, 1), len(ranked))] {
+		picked[i] = true
+	}
+	for i := range notes {
+		picked[i] = true
+	}
+
+	var cues []ReviewCue
+	for i, seg := range segs {
+		if picked[i] {
+			cues = append(cues, ReviewCue{Index: i + 1, Segment: seg, Note: notes[i]})
+		}
+	}
+	return cues
+}
+
+// reviewPath returns where the review report of the subtitle file at
+// subPath goes: next to it, e.g. "Movie.eng.review.html" for
+// "Movie.eng.srt".
+func reviewPath(subPath, format string) string {
+	return strings.TrimSuffix(subPath, filepath.Ext(subPath)) + ".review." + format
+}
+
+// WriteReview writes a review report for the subtitle file named title,
+// which has total cues, in format (ReviewText or ReviewHTML). subFormat is
+// the subtitle format ("srt", "vtt", ...), so timestamps read as in the file.
+func WriteReview(w io.Writer, format, title, subFormat string, cues []ReviewCue, total int) error {
+	if format == ReviewHTML {
+		return reviewTemplate.Execute(w, reviewData(title, subFormat, cues, total))
+	}
+	if _, err := fmt.Fprintf(w, "Review: %s\n%d of %d cues to check\n\n", title, len(cues), total); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CUE\tTIME\tCONFIDENCE\tTEXT")
+	for _, c := range reviewData(title, subFormat, cues, total).Cues {
+		text := c.Text
+		if c.Note != "" {
+			text = "(" + c.Note + ") " + text
+		}
+		fmt.Fprintf(tw, "%d\t%s --> %s\t%s\t%s\n", c.Index, c.Start, c.End, c.Confidence, text)
+	}
+	return tw.Flush()
+}
+
+// reviewView is a review report's content, formatted for display.
+type reviewView struct {
+	Title string
+	Total int
+	Cues  []reviewCueView
+}
+
+type reviewCueView struct {
+	Index                        int
+	Start, End, Confidence, Text string
+	Note                         string
+}
+
+func reviewData(title, subFormat string, cues []ReviewCue, total int) reviewView {
+	v := reviewView{Title: title, Total: total}
+	for _, c := range cues {
+		conf := "?"
+		if c.Segment.Confidence > 0 {
+			conf = fmt.Sprintf("%.0f%%", c.Segment.Confidence*100)
+		}
+		v.Cues = append(v.Cues, reviewCueView{
+			Index:      c.Index,
+			Start:      FormatTimestamp(c.Segment.Start, subFormat),
+			End:        FormatTimestamp(c.Segment.End, subFormat),
+			Confidence: conf,
+			Text:       strings.Join(strings.Fields(c.Segment.Text), " "),
+			Note:       c.Note,
+		})
+	}
+	return v
+}
+
+var reviewTemplate = template.Must(template.New("review").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Review: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; text-align: left; vertical-align: top; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.note { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Review: {{.Title}}</h1>
+<p>{{len .Cues}} of {{.Total}} cues to check</p>
+<table>
+<tr><th>Cue</th><th>Time</th><th>Confidence</th><th>Text</th></tr>
+{{- range .Cues}}
+<tr><td class="num">{{.Index}}</td><td>{{.Start}} --&gt; {{.End}}</td><td class="num">{{.Confidence}}</td><td>{{if .Note}}<span class="note">({{.Note}})</span> {{end}}{{.Text}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectReview(t *testing.T) {
+	var segs []Segment
+	for i := range 40 {
+		segs = append(segs, Segment{
+			Start:      time.Duration(i) * time.Second,
+			End:        time.Duration(i+1) * time.Second,
+			Text:       fmt.Sprintf("line %d", i),
+			Confidence: 0.5 + float64(i%10)/20,
+		})
+	}
+	segs[7].Confidence = 0.2
+	segs[25].Confidence = 0.1
+	segs[3].Confidence = 0 // unknown, never ranked
+	found := []Hallucination{
+		{Segment: segs[30], Reason: "boilerplate", Action: "kept"},
+		{Segment: Segment{Start: 31 * time.Second, Text: "gone"}, Reason: "repeated", Action: "dropped"},
+	}
+
+	cues := SelectReview(segs, 5, found)
+	var got []string
+	for _, c := range cues {
+		got = append(got, fmt.Sprintf("%d %s", c.Index, c.Note))
+	}
+	// The worst 5% of 39 ranked cues is 2, in file order, plus the kept
+	// hallucination.
+	if want := "8 |26 |31 boilerplate"; strings.Join(got, "|") != want {
+		t.Errorf("SelectReview = %q; want %q", strings.Join(got, "|"), want)
+	}
+
+	if cues := SelectReview(segs[:5], 5, nil); len(cues) != 1 || cues[0].Index != 1 {
+		t.Errorf("SelectReview of 5 cues = %+v; want the least confident one", cues)
+	}
+	if cues := SelectReview(nil, 5, nil); len(cues) != 0 {
+		t.Errorf("SelectReview of no cues = %+v", cues)
+	}
+}
+
+func TestWriteReview(t *testing.T) {
+	cues := []ReviewCue{
+		{Index: 8, Segment: Segment{Start: 7 * time.Second, End: 8 * time.Second, Text: " Fish & <chips>\nplease", Confidence: 0.234}},
+		{Index: 31, Segment: Segment{Start: 90 * time.Second, End: 92 * time.Second, Text: "Thanks for watching!"}, Note: "boilerplate"},
+	}
+
+	var b bytes.Buffer
+	if err := WriteReview(&b, ReviewText, "Movie.eng.srt", "srt", cues, 40); err != nil {
+		t.Fatal(err)
+	}
+	want := `Review: Movie.eng.srt
+2 of 40 cues to check
+
+CUE  TIME                           CONFIDENCE  TEXT
+8    00:00:07,000 --> 00:00:08,000  23%         Fish & <chips> please
+31   00:01:30,000 --> 00:01:32,000  ?           (boilerplate) Thanks for watching!
+`
+	if b.String() != want {
+		t.Errorf("text report:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := WriteReview(&b, ReviewHTML, "Movie.eng.vtt", "vtt", cues, 40); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"<title>Review: Movie.eng.vtt</title>",
+		"<p>2 of 40 cues to check</p>",
+		`<td class="num">8</td><td>00:00:07.000 --&gt; 00:00:08.000</td><td class="num">23%</td><td>Fish &amp; &lt;chips&gt; please</td>`,
+		`<span class="note">(boilerplate)</span> Thanks for watching!`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("HTML report lacks %q:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestReviewPath(t *testing.T) {
+	if got := reviewPath("/media/Movie (2020).eng.srt", ReviewHTML); got != "/media/Movie (2020).eng.review.html" {
+		t.Errorf("reviewPath = %q", got)
+	}
+}
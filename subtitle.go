@@ -1,17 +1,27 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 	"time"
 )
 
 // Segment represents a single subtitle segment with start/end times and text.
+// Language is the whisper language code the segment was transcribed in, or
+// "" when unknown (only set in code-switching mode).
 type Segment struct {
-	Start time.Duration
-	End   time.Duration
-	Text  string
+	Start    time.Duration
+	End      time.Duration
+	Text     string
+	Language string
+
+	// Confidence is the geometric mean of the probabilities whisper gave
+	// the segment's text tokens, times its probability that the segment is
+	// speech, from 0 to 1; 0 if unknown.
+	Confidence float64
 }
 
 // FormatTimestamp converts a time.Duration into an SRT or VTT timestamp string.
@@ -19,7 +29,8 @@ type Segment struct {
 // SRT format: HH:MM:SS,mmm  (comma separator)
 // VTT format: HH:MM:SS.mmm  (dot separator)
 //
-// The format argument should be "srt" or "vtt".
+// The format argument should be "srt", "vtt" or "ttml" (which uses the VTT
+// form).
 func FormatTimestamp(d time.Duration, format string) string {
 	total := d.Milliseconds()
 	ms := total % 1000
@@ -36,6 +47,127 @@ func FormatTimestamp(d time.Duration, format string) string {
 	return ts
 }
 
+// SubtitleWriter writes segments to a subtitle file one at a time, so the
+// file can grow while the audio is still being transcribed.
+type SubtitleWriter struct {
+	w      io.Writer
+	format string
+	n      int
+}
+
+// NewSubtitleWriter starts a subtitle file on w in the given format ("srt",
+// "vtt", "ttml" or "json"), writing the WebVTT header or opening the TTML
+// document or JSON array.
+func NewSubtitleWriter(w io.Writer, format string) (*SubtitleWriter, error) {
+	header := ""
+	switch format {
+	case "vtt":
+		header = "WEBVTT\n\n"
+	case "ttml":
+		header = ttmlHeader
+	case "json":
+		header = "["
+	}
+	if _, err := fmt.Fprint(w, header); err != nil {
+		return nil, err
+	}
+	return &SubtitleWriter{w: w, format: format}, nil
+}
+
+// TTML documents are written as a single div of paragraphs, one per cue.
+const (
+	ttmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<tt xmlns="http://www.w3.org/ns/ttml" xml:lang="">
+  <body>
+    <div>
+`
+	ttmlFooter = `    </div>
+  </body>
+</tt>
+`
+)
+
+// markupEscaper escapes cue text for WebVTT and TTML, where "&", "<" and
+// ">" would otherwise be read as markup.
+var markupEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// jsonCue is a segment in the JSON format.
+type jsonCue struct {
+	Start      float64 `json:"start"` // seconds
+	End        float64 `json:"end"`
+	Text       string  `json:"text"`
+	Language   string  `json:"language,omitempty"` // BCP-47 tag
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// Write appends one segment as the next cue.
+func (s *SubtitleWriter) Write(seg Segment) error {
+	s.n++
+	if s.format == "json" {
+		data, err := json.Marshal(jsonCue{
+			Start:      seg.Start.Seconds(),
+			End:        seg.End.Seconds(),
+			Text:       strings.TrimSpace(seg.Text),
+			Language:   languageTag(seg.Language),
+			Confidence: math.Round(seg.Confidence*1000) / 1000,
+		})
+		if err != nil {
+			return err
+		}
+		sep := ",\n  "
+		if s.n == 1 {
+			sep = "\n  "
+		}
+		_, err = fmt.Fprintf(s.w, "%s%s", sep, data)
+		return err
+	}
+	start := FormatTimestamp(seg.Start, s.format)
+	end := FormatTimestamp(seg.End, s.format)
+	text := strings.TrimSpace(seg.Text)
+	switch s.format {
+	case "vtt":
+	case "ttml":
+		lang := ""
+		if seg.Language != "" {
+			lang = ` xml:lang="` + languageTag(seg.Language) + `"`
+		}
+		_, err := fmt.Fprintf(s.w, "      <p begin=\"%s\" end=\"%s\"%s>%s</p>\n", start, end, lang, markupEscaper.Replace(text))
+		return err
+	default:
+		_, err := fmt.Fprintf(s.w, "%d\n%s --> %s\n%s\n\n", s.n, start, end, text)
+		return err
+	}
+	text = markupEscaper.Replace(text)
+	if seg.Language != "" {
+		text = "<lang " + languageTag(seg.Language) + ">" + text + "</lang>"
+	}
+	_, err := fmt.Fprintf(s.w, "%s --> %s\n%s\n\n", start, end, text)
+	return err
+}
+
+// Close ends the file, closing the TTML document or JSON array; it does
+// not close the underlying writer. SRT and WebVTT need no ending.
+func (s *SubtitleWriter) Close() error {
+	footer := ""
+	switch s.format {
+	case "ttml":
+		footer = ttmlFooter
+	case "json":
+		footer = "\n]\n"
+	}
+	_, err := fmt.Fprint(s.w, footer)
+	return err
+}
+
+// languageTag returns the BCP-47 tag of a whisper language code, or the
+// code itself if it is unknown.
+func languageTag(code string) string {
+	if l, ok := LookupLanguage(code); ok {
+		return l.BCP47
+	}
+	return code
+}
+
 // WriteSRT writes segments in SRT (SubRip) format to w.
 //
 // SRT format:
@@ -47,16 +179,7 @@ func FormatTimestamp(d time.Duration, format string) string {
 //	2
 //	...
 func WriteSRT(w io.Writer, segments []Segment) error {
-	for i, seg := range segments {
-		start := FormatTimestamp(seg.Start, "srt")
-		end := FormatTimestamp(seg.End, "srt")
-		text := strings.TrimSpace(seg.Text)
-		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, start, end, text)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return writeSubtitles(w, "srt", segments)
 }
 
 // WriteVTT writes segments in WebVTT format to w.
@@ -70,18 +193,50 @@ func WriteSRT(w io.Writer, segments []Segment) error {
 //
 //	00:00:03.000 --> 00:00:05.000
 //	...
+//
+// Cue text is escaped, and segments with a Language are wrapped in a <lang>
+// cue span carrying the BCP-47 tag, so players can tell which language each
+// cue is in.
 func WriteVTT(w io.Writer, segments []Segment) error {
-	if _, err := fmt.Fprint(w, "WEBVTT\n\n"); err != nil {
+	return writeSubtitles(w, "vtt", segments)
+}
+
+// WriteTTML writes segments as a TTML (Timed Text Markup Language)
+// document to w. Segments with a Language carry it as the xml:lang of
+// their paragraph.
+//
+//	<tt xmlns="http://www.w3.org/ns/ttml" xml:lang="">
+//	  <body>
+//	    <div>
+//	      <p begin="00:00:00.000" end="00:00:02.000" xml:lang="en">Hello world</p>
+//	      ...
+func WriteTTML(w io.Writer, segments []Segment) error {
+	return writeSubtitles(w, "ttml", segments)
+}
+
+// WriteJSON writes segments as a JSON array of cues to w, keeping what
+// SRT cannot carry: each cue's language (as a BCP-47 tag, for
+// code-switching output) and confidence.
+//
+//	[
+//	  {"start":0,"end":2,"text":"Hello world","language":"en","confidence":0.93},
+//	  ...
+//	]
+//
+// Times are in seconds; language and confidence are omitted if unknown.
+func WriteJSON(w io.Writer, segments []Segment) error {
+	return writeSubtitles(w, "json", segments)
+}
+
+func writeSubtitles(w io.Writer, format string, segments []Segment) error {
+	sw, err := NewSubtitleWriter(w, format)
+	if err != nil {
 		return err
 	}
 	for _, seg := range segments {
-		start := FormatTimestamp(seg.Start, "vtt")
-		end := FormatTimestamp(seg.End, "vtt")
-		text := strings.TrimSpace(seg.Text)
-		_, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", start, end, text)
-		if err != nil {
+		if err := sw.Write(seg); err != nil {
 			return err
 		}
 	}
-	return nil
+	return sw.Close()
 }
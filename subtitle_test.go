@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/xml"
 	"strings"
 	"testing"
 	"time"
@@ -209,3 +210,124 @@ func TestWriteVTT_TrimsWhitespace(t *testing.T) {
 		t.Errorf("WriteVTT should trim trailing whitespace from text, got:\n%s", out)
 	}
 }
+
+func TestWriteVTT_LanguageSpan(t *testing.T) {
+	segments := []Segment{
+		{Start: 0, End: 1 * time.Second, Text: " Hello ", Language: "en"},
+		{Start: 2 * time.Second, End: 3 * time.Second, Text: "Untagged"},
+	}
+	var buf bytes.Buffer
+	err := WriteVTT(&buf, segments)
+	if err != nil {
+		t.Fatalf("WriteVTT returned error: %v", err)
+	}
+	out := buf.String()
+
+	if !strings.Contains(out, "<lang en>Hello</lang>\n") {
+		t.Errorf("WriteVTT should wrap tagged cues in a lang span, got:\n%s", out)
+	}
+	if !strings.Contains(out, "\nUntagged\n") {
+		t.Errorf("WriteVTT should leave untagged cues unchanged, got:\n%s", out)
+	}
+}
+
+func TestWriteVTT_EscapesMarkup(t *testing.T) {
+	segments := []Segment{{Start: 0, End: time.Second, Text: "Fish & <chips>", Language: "en"}}
+	var buf bytes.Buffer
+	if err := WriteVTT(&buf, segments); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<lang en>Fish &amp; &lt;chips&gt;</lang>\n") {
+		t.Errorf("WriteVTT should escape cue text, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteTTML(t *testing.T) {
+	segments := []Segment{
+		{Start: 1500 * time.Millisecond, End: 3 * time.Second, Text: " Hola ", Language: "es"},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: "Q&A <live>"},
+	}
+	var buf bytes.Buffer
+	if err := WriteTTML(&buf, segments); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<tt xmlns="http://www.w3.org/ns/ttml" xml:lang="">
+  <body>
+    <div>
+      <p begin="00:00:01.500" end="00:00:03.000" xml:lang="es">Hola</p>
+      <p begin="00:00:03.000" end="00:00:04.000">Q&amp;A &lt;live&gt;</p>
+    </div>
+  </body>
+</tt>
+`
+	if buf.String() != want {
+		t.Errorf("WriteTTML:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+		t.Errorf("WriteTTML output is not well-formed XML: %v", err)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	segments := []Segment{
+		{Start: 1500 * time.Millisecond, End: 3 * time.Second, Text: " Hola ", Language: "es", Confidence: 0.91234},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: `Say "hi"`},
+	}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, segments); err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {"start":1.5,"end":3,"text":"Hola","language":"es","confidence":0.912},
+  {"start":3,"end":4,"text":"Say \"hi\""}
+]
+`
+	if buf.String() != want {
+		t.Errorf("WriteJSON:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	WriteJSON(&buf, nil)
+	if buf.String() != "[\n]\n" {
+		t.Errorf("WriteJSON(nil) = %q; want an empty array", buf.String())
+	}
+}
+
+// ---------------------------------------------------------------------------
+// SubtitleWriter tests
+// ---------------------------------------------------------------------------
+
+func TestSubtitleWriter_MatchesWholeFile(t *testing.T) {
+	segments := []Segment{
+		{Start: 0, End: 2 * time.Second, Text: "Hello"},
+		{Start: 3 * time.Second, End: 5 * time.Second, Text: "World", Language: "de"},
+	}
+	for _, format := range []string{"srt", "vtt", "ttml", "json"} {
+		var whole, streamed bytes.Buffer
+		switch format {
+		case "vtt":
+			WriteVTT(&whole, segments)
+		case "ttml":
+			WriteTTML(&whole, segments)
+		case "json":
+			WriteJSON(&whole, segments)
+		default:
+			WriteSRT(&whole, segments)
+		}
+
+		sw, err := NewSubtitleWriter(&streamed, format)
+		if err != nil {
+			t.Fatalf("NewSubtitleWriter(%s): %v", format, err)
+		}
+		for _, seg := range segments {
+			if err := sw.Write(seg); err != nil {
+				t.Fatalf("Write(%s): %v", format, err)
+			}
+		}
+		sw.Close()
+		if streamed.String() != whole.String() {
+			t.Errorf("%s: streamed output\n%s\ndiffers from\n%s", format, streamed.String(), whole.String())
+		}
+	}
+}
@@ -2,27 +2,37 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 )
 
 // clearLines moves the cursor up n lines and clears each one.
-func clearLines(n int) {
+func clearLines(w io.Writer, n int) {
 	for i := 0; i < n; i++ {
-		fmt.Print("\033[A\033[2K")
+		fmt.Fprint(w, "\033[A\033[2K")
 	}
 }
 
 // PickAudioTracks selects audio stream indices from the probed tracks.
 // If manual >= 0, it is used directly. Otherwise:
 //   - single track: returns it
-//   - multiple tracks: presents an interactive menu with an "all" option
+//   - multiple tracks: presents an interactive menu with an "all" option,
+//     or returns an error if stdin has no answer to give
 //   - no tracks: returns nil and an error
 func PickAudioTracks(tracks []AudioTrack, manual int) ([]int, error) {
+	return PickAudioTracksFrom(context.Background(), tracks, manual, os.Stdin, os.Stdout)
+}
+
+// PickAudioTracksFrom is PickAudioTracks reading the answer from in and
+// printing the menu to out. Cancelling ctx stops waiting for the answer
+// and returns ctx.Err().
+func PickAudioTracksFrom(ctx context.Context, tracks []AudioTrack, manual int, in io.Reader, out io.Writer) ([]int, error) {
 	if manual >= 0 {
-		fmt.Printf("  Using audio track %d (manual)\n", manual)
+		fmt.Fprintf(out, "  Using audio track %d (manual)\n", manual)
 		return []int{manual}, nil
 	}
 
@@ -36,7 +46,7 @@ func PickAudioTracks(tracks []AudioTrack, manual int) ([]int, error) {
 		if lang == "" {
 			lang = "und"
 		}
-		fmt.Printf("  Using audio track %d (%s)\n", t.StreamIndex, lang)
+		fmt.Fprintf(out, "  Using audio track %d (%s)\n", t.StreamIndex, lang)
 		return []int{t.StreamIndex}, nil
 	}
 
@@ -44,30 +54,42 @@ func PickAudioTracks(tracks []AudioTrack, manual int) ([]int, error) {
 	// Track lines printed so we can clear the menu after selection.
 	menuLines := 0
 
-	fmt.Println("  Multiple audio tracks found:")
+	fmt.Fprintln(out, "  Multiple audio tracks found:")
 	menuLines++
 	for i, t := range tracks {
 		lang := t.Language
 		if lang == "" {
 			lang = "und"
 		}
-		fmt.Printf("    %d) stream %d — %s (%s, %dch, %dHz)\n",
+		if t.Title != "" {
+			lang += fmt.Sprintf(" %q", t.Title)
+		}
+		fmt.Fprintf(out, "    %d) stream %d — %s (%s, %dch, %dHz)\n",
 			i+1, t.StreamIndex, lang, t.Codec, t.Channels, t.SampleRate)
 		menuLines++
 	}
-	fmt.Printf("    a) all tracks\n")
+	fmt.Fprintf(out, "    a) all tracks\n")
 	menuLines++
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := bufio.NewReader(in)
 	for {
-		fmt.Printf("  Select track [1-%d or a]: ", len(tracks))
+		fmt.Fprintf(out, "  Select track [1-%d or a]: ", len(tracks))
 		menuLines++
-		line, _ := reader.ReadString('\n')
+		line, err := readLine(ctx, reader)
+		if ctx.Err() != nil {
+			fmt.Fprintln(out)
+			return nil, ctx.Err()
+		}
 		line = strings.TrimSpace(line)
+		if err != nil && line == "" {
+			// Stdin is closed or not interactive (e.g. paths were piped in).
+			fmt.Fprintln(out)
+			return nil, fmt.Errorf("multiple audio tracks and no selection; use --audio-track")
+		}
 
 		if strings.EqualFold(line, "a") || strings.EqualFold(line, "all") {
-			clearLines(menuLines)
-			fmt.Println("  Using all audio tracks")
+			clearLines(out, menuLines)
+			fmt.Fprintln(out, "  Using all audio tracks")
 			indices := make([]int, len(tracks))
 			for i, t := range tracks {
 				indices[i] = t.StreamIndex
@@ -77,21 +99,41 @@ func PickAudioTracks(tracks []AudioTrack, manual int) ([]int, error) {
 
 		n, err := strconv.Atoi(line)
 		if err == nil && n >= 1 && n <= len(tracks) {
-			clearLines(menuLines)
+			clearLines(out, menuLines)
 			t := tracks[n-1]
 			lang := t.Language
 			if lang == "" {
 				lang = "und"
 			}
-			fmt.Printf("  Using audio track %d (%s)\n", t.StreamIndex, lang)
+			fmt.Fprintf(out, "  Using audio track %d (%s)\n", t.StreamIndex, lang)
 			return []int{t.StreamIndex}, nil
 		}
 
-		fmt.Printf("  Invalid choice. Enter a number between 1 and %d, or 'a' for all.\n", len(tracks))
+		fmt.Fprintf(out, "  Invalid choice. Enter a number between 1 and %d, or 'a' for all.\n", len(tracks))
 		menuLines++
 	}
 }
 
+// readLine reads a line from r, giving up when ctx is cancelled. The read
+// itself cannot be interrupted, so it is left to finish in the background.
+func readLine(ctx context.Context, r *bufio.Reader) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := r.ReadString('\n')
+		done <- result{line, err}
+	}()
+	select {
+	case res := <-done:
+		return res.line, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
 // TrackLanguage returns the language for a given stream index from the tracks list,
 // or "und" if not found.
 func TrackLanguage(tracks []AudioTrack, streamIndex int) string {
@@ -105,3 +147,14 @@ func TrackLanguage(tracks []AudioTrack, streamIndex int) string {
 	}
 	return "und"
 }
+
+// TrackTitle returns the title for a given stream index from the tracks list,
+// or "" if the track is untitled or not found.
+func TrackTitle(tracks []AudioTrack, streamIndex int) string {
+	for _, t := range tracks {
+		if t.StreamIndex == streamIndex {
+			return t.Title
+		}
+	}
+	return ""
+}
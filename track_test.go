@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPickAudioTracksManual(t *testing.T) {
@@ -152,3 +154,53 @@ func TestTrackLanguage(t *testing.T) {
 		t.Errorf("expected und for missing, got %s", got)
 	}
 }
+
+func TestTrackTitle(t *testing.T) {
+	tracks := []AudioTrack{
+		{StreamIndex: 1, Language: "eng", Title: "Commentary"},
+		{StreamIndex: 2, Language: "eng"},
+	}
+	if got := TrackTitle(tracks, 1); got != "Commentary" {
+		t.Errorf("expected Commentary, got %q", got)
+	}
+	if got := TrackTitle(tracks, 2); got != "" {
+		t.Errorf("expected empty title, got %q", got)
+	}
+	if got := TrackTitle(tracks, 99); got != "" {
+		t.Errorf("expected empty title for missing, got %q", got)
+	}
+}
+
+func TestPickAudioTracksClosedStdin(t *testing.T) {
+	tracks := []AudioTrack{
+		{StreamIndex: 1, Language: "eng", Codec: "aac", Channels: 2, SampleRate: 48000},
+		{StreamIndex: 2, Language: "spa", Codec: "aac", Channels: 2, SampleRate: 48000},
+	}
+
+	// Simulate stdin that has already been consumed (e.g. a piped path list).
+	r, w, _ := os.Pipe()
+	w.Close()
+	oldStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin }()
+
+	if _, err := PickAudioTracks(tracks, -1); err == nil {
+		t.Fatal("expected error when stdin is closed")
+	}
+}
+
+func TestPickAudioTracksCancelled(t *testing.T) {
+	tracks := []AudioTrack{{StreamIndex: 1, Language: "eng"}, {StreamIndex: 2, Language: "spa"}}
+
+	// A terminal nobody answers: the read blocks until the test ends.
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var out strings.Builder
+	if _, err := PickAudioTracksFrom(ctx, tracks, -1, r, &out); err != context.Canceled {
+		t.Errorf("PickAudioTracksFrom after cancel = %v; want context.Canceled", err)
+	}
+}
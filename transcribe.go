@@ -5,19 +5,24 @@ package main
 #include "whisper.h"
 */
 import "C"
-import "runtime"
+import (
+	"context"
+	"runtime"
+)
 
 // DetectLanguage analyses the first 30 seconds of audio and returns the
 // ISO-639-1 code of the most likely language (e.g. "en", "ru").
 // This is a lightweight operation compared to full transcription.
-func (m *WhisperModel) DetectLanguage(samples []float32) string {
-	if m.ctx == nil || len(samples) == 0 {
+// whisper.cpp cannot abort detection, so ctx is checked between its
+// steps; "" is returned once it is cancelled.
+func (m *WhisperModel) DetectLanguage(ctx context.Context, samples []float32) string {
+	if m.ctx == nil || len(samples) == 0 || ctx.Err() != nil {
 		return ""
 	}
 
 	// whisper_pcm_to_mel computes the mel spectrogram (uses first 30s).
 	ret := C.whisper_pcm_to_mel(m.ctx, (*C.float)(&samples[0]), C.int(len(samples)), C.int(runtime.NumCPU()))
-	if ret != 0 {
+	if ret != 0 || ctx.Err() != nil {
 		return ""
 	}
 
@@ -29,6 +34,24 @@ func (m *WhisperModel) DetectLanguage(samples []float32) string {
 	return C.GoString(C.whisper_lang_str(id))
 }
 
+// DetectLanguage is WhisperModel.DetectLanguage using this state.
+func (s *WhisperState) DetectLanguage(ctx context.Context, samples []float32) string {
+	if s.state == nil || s.model.ctx == nil || len(samples) == 0 || ctx.Err() != nil {
+		return ""
+	}
+
+	ret := C.whisper_pcm_to_mel_with_state(s.model.ctx, s.state, (*C.float)(&samples[0]), C.int(len(samples)), C.int(s.threads))
+	if ret != 0 || ctx.Err() != nil {
+		return ""
+	}
+
+	id := C.whisper_lang_auto_detect_with_state(s.model.ctx, s.state, 0, C.int(s.threads), nil)
+	if id < 0 {
+		return ""
+	}
+	return C.GoString(C.whisper_lang_str(id))
+}
+
 // IsMultilingual reports whether the loaded model supports multiple
 // languages.  Monolingual models (e.g. *.en) only support English.
 func (m *WhisperModel) IsMultilingual() bool {
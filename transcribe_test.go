@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"math"
+	"reflect"
 	"testing"
 )
 
@@ -27,7 +30,7 @@ func generateSilence(sampleRate, durationSec int) []float32 {
 // transcription returns without error, segment timestamps are sane) rather
 // than the linguistic quality of the output.
 func TestTranscribe(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -41,7 +44,7 @@ func TestTranscribe(t *testing.T) {
 	// 3 seconds of 440 Hz sine wave at 16 kHz (whisper's expected sample rate).
 	samples := generateSineWave(440, 16000, 3)
 
-	segments, err := model.Transcribe(samples, "", nil)
+	segments, err := model.Transcribe(context.Background(), samples, TranscribeOptions{})
 	if err != nil {
 		t.Fatal("Transcription failed:", err)
 	}
@@ -61,7 +64,7 @@ func TestTranscribe(t *testing.T) {
 // TestTranscribeSilence feeds pure silence to verify the API handles quiet
 // input gracefully (no crash, no error).
 func TestTranscribeSilence(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -74,7 +77,7 @@ func TestTranscribeSilence(t *testing.T) {
 
 	samples := generateSilence(16000, 2)
 
-	segments, err := model.Transcribe(samples, "", nil)
+	segments, err := model.Transcribe(context.Background(), samples, TranscribeOptions{})
 	if err != nil {
 		t.Fatal("Transcription on silence failed:", err)
 	}
@@ -91,7 +94,7 @@ func TestTranscribeSilence(t *testing.T) {
 // TestDetectLanguage ensures the standalone language detection works
 // without running full transcription.
 func TestDetectLanguage(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -104,7 +107,7 @@ func TestDetectLanguage(t *testing.T) {
 
 	samples := generateSineWave(440, 16000, 3)
 
-	lang := model.DetectLanguage(samples)
+	lang := model.DetectLanguage(context.Background(), samples)
 	if lang == "" {
 		t.Error("DetectLanguage returned empty string")
 	}
@@ -113,7 +116,7 @@ func TestDetectLanguage(t *testing.T) {
 
 // TestTranscribeWithLanguage exercises the explicit language parameter.
 func TestTranscribeWithLanguage(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -126,7 +129,7 @@ func TestTranscribeWithLanguage(t *testing.T) {
 
 	samples := generateSineWave(440, 16000, 3)
 
-	segments, err := model.Transcribe(samples, "en", nil)
+	segments, err := model.Transcribe(context.Background(), samples, TranscribeOptions{Language: "en"})
 	if err != nil {
 		t.Fatal("Transcription with language=en failed:", err)
 	}
@@ -148,7 +151,7 @@ func TestLoadModelInvalid(t *testing.T) {
 // TestTranscribeEmptySamples verifies that Transcribe returns an error
 // when given an empty sample slice.
 func TestTranscribeEmptySamples(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -159,7 +162,7 @@ func TestTranscribeEmptySamples(t *testing.T) {
 	}
 	defer model.Close()
 
-	_, err = model.Transcribe([]float32{}, "", nil)
+	_, err = model.Transcribe(context.Background(), []float32{}, TranscribeOptions{})
 	if err == nil {
 		t.Fatal("Expected error for empty samples")
 	}
@@ -167,7 +170,7 @@ func TestTranscribeEmptySamples(t *testing.T) {
 
 // TestIsMultilingual checks the model capability query.
 func TestIsMultilingual(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -186,7 +189,7 @@ func TestIsMultilingual(t *testing.T) {
 
 // TestModelDoubleClose verifies that calling Close twice does not panic.
 func TestModelDoubleClose(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -203,7 +206,7 @@ func TestModelDoubleClose(t *testing.T) {
 // TestTranscribeAfterClose verifies that Transcribe on a closed model
 // returns an error rather than crashing.
 func TestTranscribeAfterClose(t *testing.T) {
-	modelPath, err := EnsureModel("tiny")
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
 	if err != nil {
 		t.Skip("Could not obtain tiny model:", err)
 	}
@@ -215,8 +218,99 @@ func TestTranscribeAfterClose(t *testing.T) {
 	model.Close()
 
 	samples := generateSineWave(440, 16000, 1)
-	_, err = model.Transcribe(samples, "", nil)
+	_, err = model.Transcribe(context.Background(), samples, TranscribeOptions{})
 	if err == nil {
 		t.Fatal("Expected error when transcribing after Close")
 	}
 }
+
+// TestStatesTranscribeConcurrently runs two states of one model at the same
+// time and checks both produce segments.
+func TestStatesTranscribeConcurrently(t *testing.T) {
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
+	if err != nil {
+		t.Skip("Could not obtain tiny model:", err)
+	}
+
+	model, err := LoadModel(modelPath)
+	if err != nil {
+		t.Fatal("Failed to load model:", err)
+	}
+	defer model.Close()
+
+	samples := generateSineWave(440, 16000, 3)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		state, err := model.NewState(1)
+		if err != nil {
+			t.Fatal("Failed to create state:", err)
+		}
+		defer state.Close()
+		go func() {
+			segments, err := state.Transcribe(context.Background(), samples, TranscribeOptions{})
+			if err == nil && len(segments) == 0 {
+				err = errors.New("no segments")
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error("Concurrent transcription failed:", err)
+		}
+	}
+}
+
+// TestTranscribeCancelled verifies that a cancelled context aborts
+// transcription with the context's error.
+func TestTranscribeCancelled(t *testing.T) {
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
+	if err != nil {
+		t.Skip("Could not obtain tiny model:", err)
+	}
+
+	model, err := LoadModel(modelPath)
+	if err != nil {
+		t.Fatal("Failed to load model:", err)
+	}
+	defer model.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	samples := generateSineWave(440, 16000, 60)
+	segments, err := model.Transcribe(ctx, samples, TranscribeOptions{Language: "en", OnProgress: func(pct int) {
+		if pct > 0 {
+			cancel()
+		}
+	}})
+	cancel()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Transcribe after cancel: segments=%d err=%v; want context.Canceled", len(segments), err)
+	}
+}
+
+// TestTranscribeOnSegment verifies that OnSegment sees every returned
+// segment, in order.
+func TestTranscribeOnSegment(t *testing.T) {
+	modelPath, err := EnsureModel("tiny", ModelOptions{})
+	if err != nil {
+		t.Skip("Could not obtain tiny model:", err)
+	}
+
+	model, err := LoadModel(modelPath)
+	if err != nil {
+		t.Fatal("Failed to load model:", err)
+	}
+	defer model.Close()
+
+	var streamed []Segment
+	samples := generateSineWave(440, 16000, 3)
+	segments, err := model.Transcribe(context.Background(), samples, TranscribeOptions{
+		OnSegment: func(seg Segment) { streamed = append(streamed, seg) },
+	})
+	if err != nil {
+		t.Fatal("Transcription failed:", err)
+	}
+	if !reflect.DeepEqual(streamed, segments) {
+		t.Errorf("streamed segments %+v; returned %+v", streamed, segments)
+	}
+}
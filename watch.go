@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirNotifier wakes the watcher when entries change in a watched directory.
+// It is implemented with inotify on Linux; elsewhere newDirNotifier returns
+// an error and the watcher relies on polling alone.
+type dirNotifier interface {
+	Add(dir string) error
+	Events() <-chan struct{}
+	Close() error
+}
+
+// watchedFile is the last observed state of a file that is not yet ready.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // when this size and mtime were first observed
+}
+
+// defaultWatchRetry is how long the watcher waits before reporting a file
+// whose processing failed again.
+const defaultWatchRetry = 10 * time.Minute
+
+// Watcher reports media files that appear in a set of directories once
+// they have been fully written, i.e. their size and modification time have
+// not changed for Settle.
+type Watcher struct {
+	Dirs     []string
+	Opts     DiscoverOptions
+	Interval time.Duration // how often directories are rescanned
+	Settle   time.Duration // how long a file must stay unchanged
+	Retry    time.Duration // how long after a failure a file is reported again
+
+	pending  map[string]watchedFile
+	done     map[string]time.Time // reported files and their mtime at the time
+	existing map[string]time.Time // files present at startup and their mtime then
+	retry    map[string]time.Time // failed files and when to report them again
+	now      func() time.Time
+}
+
+// NewWatcher creates a Watcher for dirs, discovering files with opts.
+func NewWatcher(dirs []string, opts DiscoverOptions, interval, settle time.Duration) *Watcher {
+	return &Watcher{
+		Dirs:     dirs,
+		Opts:     opts,
+		Interval: interval,
+		Settle:   settle,
+		Retry:    defaultWatchRetry,
+		pending:  map[string]watchedFile{},
+		done:     map[string]time.Time{},
+		existing: map[string]time.Time{},
+		retry:    map[string]time.Time{},
+		now:      time.Now,
+	}
+}
+
+// Prime records the media files currently present. They are reported like
+// new files, e.g. recordings that arrived while the watcher was not
+// running, but Existing tells them apart so their subtitles are not made
+// again.
+func (w *Watcher) Prime() {
+	for _, f := range DiscoverMediaFiles(w.Dirs, w.Opts) {
+		if info, err := os.Stat(f.Path); err == nil {
+			w.existing[f.Path] = info.ModTime()
+		}
+	}
+}
+
+// Existing reports whether the file at path was present, unchanged since,
+// when Prime was called.
+func (w *Watcher) Existing(path string) bool {
+	mt, ok := w.existing[path]
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && mt.Equal(info.ModTime())
+}
+
+// Failed reports that a file the watcher reported could not be processed;
+// it is reported again after Retry unless it changes before then.
+func (w *Watcher) Failed(path string) {
+	if _, ok := w.done[path]; ok {
+		w.retry[path] = w.now().Add(w.Retry)
+	}
+}
+
+// Scan rescans the directories and returns the files that have become
+// ready since the last scan. A file that was reported before is reported
+// again if it is later replaced or modified.
+func (w *Watcher) Scan() []MediaFile {
+	now := w.now()
+	seen := map[string]bool{}
+	var ready []MediaFile
+	for _, f := range DiscoverMediaFiles(w.Dirs, w.Opts) {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			continue
+		}
+		seen[f.Path] = true
+		if mt, ok := w.done[f.Path]; ok && mt.Equal(info.ModTime()) {
+			if at, ok := w.retry[f.Path]; ok && !now.Before(at) {
+				delete(w.retry, f.Path)
+				ready = append(ready, f)
+			}
+			continue
+		}
+		delete(w.retry, f.Path)
+
+		cur := watchedFile{size: info.Size(), modTime: info.ModTime(), since: now}
+		prev, ok := w.pending[f.Path]
+		if !ok || prev.size != cur.size || !prev.modTime.Equal(cur.modTime) {
+			w.pending[f.Path] = cur
+			continue
+		}
+		if now.Sub(prev.since) >= w.Settle {
+			delete(w.pending, f.Path)
+			w.done[f.Path] = cur.modTime
+			ready = append(ready, f)
+		}
+	}
+
+	// Forget files that have disappeared.
+	for p := range w.pending {
+		if !seen[p] {
+			delete(w.pending, p)
+		}
+	}
+	for p := range w.done {
+		if !seen[p] {
+			delete(w.done, p)
+			delete(w.retry, p)
+			delete(w.existing, p)
+		}
+	}
+	return ready
+}
+
+// Run scans every Interval, and additionally whenever the directories
+// change if change notifications are available, passing ready files to
+// handle. handle runs synchronously; changes that happen meanwhile are
+// picked up by the next scan. Run returns when stop is closed.
+func (w *Watcher) Run(stop <-chan struct{}, handle func([]MediaFile)) {
+	var events <-chan struct{}
+	notifier, err := newDirNotifier(ignoredEntry)
+	if err == nil {
+		defer notifier.Close()
+		events = notifier.Events()
+		fmt.Printf("Watching for new files (inotify, settle %s)...\n", w.Settle)
+	} else {
+		notifier = nil
+		fmt.Printf("Watching for new files (polling every %s, settle %s)...\n", w.Interval, w.Settle)
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if notifier != nil {
+			w.watchDirs(notifier)
+		}
+		if ready := w.Scan(); len(ready) > 0 {
+			handle(ready)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+			}
+		}
+	}
+}
+
+// watchDirs registers the watched directories (and, when recursive, their
+// subdirectories) with the notifier. Directories created since the last
+// call are picked up here. Subdirectories that discovery skips (hidden,
+// excluded or too deep) are not watched.
+func (w *Watcher) watchDirs(n dirNotifier) {
+	for _, dir := range w.Dirs {
+		if !w.Opts.Recursive {
+			n.Add(dir)
+			continue
+		}
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if path != dir {
+				rel, _ := filepath.Rel(dir, path)
+				depth := strings.Count(rel, string(filepath.Separator)) + 2 // the root is depth 1
+				if (!w.Opts.Hidden && strings.HasPrefix(d.Name(), ".")) || matchAny(w.Opts.Exclude, d.Name(), rel) ||
+					(w.Opts.MaxDepth > 0 && depth > w.Opts.MaxDepth) {
+					return filepath.SkipDir
+				}
+			}
+			n.Add(path)
+			return nil
+		})
+	}
+}
+
+// ignoredEntry reports whether a change to the named directory entry
+// cannot bring new work: it is a subtitle, report, partial file or other
+// companion file, such as subline's own output.
+func ignoredEntry(name string) bool {
+	return nonMediaExts[strings.ToLower(filepath.Ext(name))]
+}
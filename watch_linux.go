@@ -0,0 +1,107 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyNotifier is a dirNotifier backed by Linux inotify.
+type inotifyNotifier struct {
+	fd      int
+	events  chan struct{}
+	ignore  func(name string) bool
+	mu      sync.Mutex
+	watched map[string]bool
+}
+
+// newDirNotifier creates an inotify-backed dirNotifier. Changes to entries
+// for which ignore returns true do not produce events.
+func newDirNotifier(ignore func(name string) bool) (dirNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	n := &inotifyNotifier{
+		fd:      fd,
+		events:  make(chan struct{}, 1),
+		ignore:  ignore,
+		watched: map[string]bool{},
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+// Add starts watching dir for entries being created, finished, moved or
+// removed. Adding the same directory again is a no-op.
+func (n *inotifyNotifier) Add(dir string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.watched[dir] {
+		return nil
+	}
+	mask := uint32(syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO |
+		syscall.IN_MOVED_FROM | syscall.IN_DELETE)
+	if _, err := syscall.InotifyAddWatch(n.fd, dir, mask); err != nil {
+		return err
+	}
+	n.watched[dir] = true
+	return nil
+}
+
+// Events delivers a value after one or more changes. Bursts of changes are
+// coalesced into a single event.
+func (n *inotifyNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+// Close releases the inotify instance.
+func (n *inotifyNotifier) Close() error {
+	return syscall.Close(n.fd)
+}
+
+// readLoop drains inotify events and signals n.events unless every event
+// read was for an ignored entry. Beyond the entry's name, the contents are
+// not needed: any change just triggers a rescan.
+func (n *inotifyNotifier) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		size, err := syscall.Read(n.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			close(n.events)
+			return
+		}
+		if !n.relevant(buf[:size]) {
+			continue
+		}
+		select {
+		case n.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// relevant reports whether any of the events in buf is about an entry that
+// is not ignored. Events without a name, such as a queue overflow, are
+// always relevant.
+func (n *inotifyNotifier) relevant(buf []byte) bool {
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		nameStart := off + syscall.SizeofInotifyEvent
+		off = nameStart + int(ev.Len)
+		if ev.Len == 0 || n.ignore == nil || off > len(buf) {
+			return true
+		}
+		name := string(bytes.TrimRight(buf[nameStart:off], "\x00"))
+		if !n.ignore(name) {
+			return true
+		}
+	}
+	return false
+}
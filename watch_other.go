@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// newDirNotifier is not implemented outside Linux; the watcher falls back
+// to polling.
+func newDirNotifier(ignore func(name string) bool) (dirNotifier, error) {
+	return nil, errors.New("change notifications not supported on this platform")
+}
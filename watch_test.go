@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestWatcher returns a Watcher over dir whose clock is controlled by the
+// returned advance function.
+func newTestWatcher(dir string, settle time.Duration) (*Watcher, func(time.Duration)) {
+	w := NewWatcher([]string{dir}, DiscoverOptions{}, time.Second, settle)
+	now := time.Unix(1000, 0)
+	w.now = func() time.Time { return now }
+	return w, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestWatcher_PrimeReportsExisting(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.mkv")
+	os.WriteFile(old, []byte("x"), 0644)
+
+	w, advance := newTestWatcher(dir, 10*time.Second)
+	w.Prime()
+	w.Scan()
+	advance(time.Minute)
+	if got := w.Scan(); len(got) != 1 || got[0].Path != old {
+		t.Fatalf("Scan after Prime = %v; want the file present at startup", got)
+	}
+	if !w.Existing(old) {
+		t.Error("file present at startup not reported as existing")
+	}
+
+	newer := filepath.Join(dir, "new.mkv")
+	os.WriteFile(newer, []byte("x"), 0644)
+	if w.Existing(newer) {
+		t.Error("file that arrived later reported as existing")
+	}
+}
+
+func TestWatcher_RetriesFailedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broken.mkv")
+	os.WriteFile(path, []byte("x"), 0644)
+
+	w, advance := newTestWatcher(dir, time.Second)
+	w.Retry = time.Hour
+	w.Scan()
+	advance(time.Second)
+	if got := w.Scan(); len(got) != 1 {
+		t.Fatalf("Scan = %v; want the file", got)
+	}
+	w.Failed(path)
+	advance(30 * time.Minute)
+	if got := w.Scan(); len(got) != 0 {
+		t.Fatalf("Scan = %v; want no retry before the delay", got)
+	}
+	advance(30 * time.Minute)
+	if got := w.Scan(); len(got) != 1 {
+		t.Fatalf("Scan = %v; want the failed file again after the delay", got)
+	}
+	advance(2 * time.Hour)
+	if got := w.Scan(); len(got) != 0 {
+		t.Errorf("Scan = %v; want a file that did not fail again left alone", got)
+	}
+}
+
+func TestWatcher_WaitsForSettle(t *testing.T) {
+	dir := t.TempDir()
+	w, advance := newTestWatcher(dir, 10*time.Second)
+	w.Prime()
+
+	path := filepath.Join(dir, "new.mkv")
+	os.WriteFile(path, []byte("x"), 0644)
+	if got := w.Scan(); len(got) != 0 {
+		t.Fatalf("first Scan = %v; want nothing until the file settles", got)
+	}
+	advance(5 * time.Second)
+	if got := w.Scan(); len(got) != 0 {
+		t.Fatalf("Scan before settle = %v; want nothing", got)
+	}
+	advance(5 * time.Second)
+	got := w.Scan()
+	if len(got) != 1 || got[0].Path != path {
+		t.Fatalf("Scan after settle = %v; want [%s]", got, path)
+	}
+	advance(time.Minute)
+	if got := w.Scan(); len(got) != 0 {
+		t.Errorf("Scan after reporting = %v; want file reported once", got)
+	}
+}
+
+func TestWatcher_GrowingFileRestartsSettle(t *testing.T) {
+	dir := t.TempDir()
+	w, advance := newTestWatcher(dir, 10*time.Second)
+
+	path := filepath.Join(dir, "copying.mkv")
+	os.WriteFile(path, []byte("x"), 0644)
+	w.Scan()
+	advance(8 * time.Second)
+	os.WriteFile(path, []byte("xxxx"), 0644) // still being written
+	w.Scan()
+	advance(8 * time.Second)
+	if got := w.Scan(); len(got) != 0 {
+		t.Fatalf("Scan = %v; want nothing, file changed 8s ago", got)
+	}
+	advance(2 * time.Second)
+	if got := w.Scan(); len(got) != 1 {
+		t.Errorf("Scan = %v; want the file once it is unchanged for 10s", got)
+	}
+}
+
+func TestWatcher_ModifiedFileReprocessed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mkv")
+	os.WriteFile(path, []byte("x"), 0644)
+
+	w, advance := newTestWatcher(dir, time.Second)
+	w.Prime()
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(path, []byte("replaced"), 0644)
+	os.Chtimes(path, later, later)
+
+	w.Scan()
+	advance(time.Second)
+	if got := w.Scan(); len(got) != 1 {
+		t.Errorf("Scan = %v; want the replaced file", got)
+	}
+}
+
+func TestWatcher_IgnoresNonMedia(t *testing.T) {
+	dir := t.TempDir()
+	w, advance := newTestWatcher(dir, time.Second)
+	os.WriteFile(filepath.Join(dir, "a.srt"), []byte("x"), 0644)
+	w.Scan()
+	advance(time.Second)
+	if got := w.Scan(); len(got) != 0 {
+		t.Errorf("Scan = %v; want subtitle files ignored", got)
+	}
+}
+
+// recordingNotifier is a dirNotifier that records the watched directories.
+type recordingNotifier struct {
+	dirs []string
+}
+
+func (n *recordingNotifier) Add(dir string) error    { n.dirs = append(n.dirs, dir); return nil }
+func (n *recordingNotifier) Events() <-chan struct{} { return nil }
+func (n *recordingNotifier) Close() error            { return nil }
+
+func TestWatcher_WatchDirsFollowsDiscoverOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"a/b/c", ".hidden", "skip/inner"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+	w := NewWatcher([]string{dir}, DiscoverOptions{Recursive: true, MaxDepth: 3, Exclude: []string{"skip"}}, time.Second, time.Second)
+	n := &recordingNotifier{}
+	w.watchDirs(n)
+	var got []string
+	for _, d := range n.dirs {
+		rel, _ := filepath.Rel(dir, d)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	if want := []string{".", "a", "a/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("watched %v; want %v", got, want)
+	}
+}
+
+func TestIgnoredEntry(t *testing.T) {
+	for name, want := range map[string]bool{
+		"movie.mkv": false, "movie.srt": true, "movie.SRT": true, "movie.json": true, "noext": false,
+		"movie.srt.partial": true, "movie.eng.review.html": true,
+	} {
+		if got := ignoredEntry(name); got != want {
+			t.Errorf("ignoredEntry(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
@@ -13,10 +13,28 @@ static void set_progress_callback(struct whisper_full_params *params, void *user
     params->progress_callback = goProgressCallback;
     params->progress_callback_user_data = user_data;
 }
+
+// CGo trampoline for the abort callback, polled during inference.
+extern bool goAbortCallback(void * user_data);
+
+static void set_abort_callback(struct whisper_full_params *params, void *user_data) {
+    params->abort_callback = goAbortCallback;
+    params->abort_callback_user_data = user_data;
+}
+
+// CGo trampoline for the new-segment callback.
+extern void goNewSegmentCallback(struct whisper_context * ctx, struct whisper_state * state, int n_new, void * user_data);
+
+static void set_new_segment_callback(struct whisper_full_params *params, void *user_data) {
+    params->new_segment_callback = goNewSegmentCallback;
+    params->new_segment_callback_user_data = user_data;
+}
 */
 import "C"
 import (
+	"context"
 	"errors"
+	"math"
 	"runtime"
 	"sync"
 	"time"
@@ -57,9 +75,122 @@ func goProgressCallback(ctx *C.struct_whisper_context, state *C.struct_whisper_s
 	}
 }
 
+// abortContexts maps an opaque ID to the context of a running
+// transcription, for the abort callback.
+var (
+	abortMu       sync.Mutex
+	abortContexts = map[uintptr]context.Context{}
+	abortNextID   uintptr
+)
+
+func registerAbort(ctx context.Context) uintptr {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+	abortNextID++
+	id := abortNextID
+	abortContexts[id] = ctx
+	return id
+}
+
+func unregisterAbort(id uintptr) {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+	delete(abortContexts, id)
+}
+
+//export goAbortCallback
+func goAbortCallback(userData unsafe.Pointer) C.bool {
+	abortMu.Lock()
+	ctx, ok := abortContexts[uintptr(userData)]
+	abortMu.Unlock()
+	return C.bool(ok && ctx.Err() != nil)
+}
+
+// segmentCallbacks maps an opaque ID to a Go callback receiving each new
+// segment.
+var (
+	segmentMu        sync.Mutex
+	segmentCallbacks = map[uintptr]func(Segment){}
+	segmentNextID    uintptr
+)
+
+func registerSegment(fn func(Segment)) uintptr {
+	segmentMu.Lock()
+	defer segmentMu.Unlock()
+	segmentNextID++
+	id := segmentNextID
+	segmentCallbacks[id] = fn
+	return id
+}
+
+func unregisterSegment(id uintptr) {
+	segmentMu.Lock()
+	defer segmentMu.Unlock()
+	delete(segmentCallbacks, id)
+}
+
+//export goNewSegmentCallback
+func goNewSegmentCallback(ctx *C.struct_whisper_context, state *C.struct_whisper_state, nNew C.int, userData unsafe.Pointer) {
+	segmentMu.Lock()
+	fn, ok := segmentCallbacks[uintptr(userData)]
+	segmentMu.Unlock()
+	if !ok {
+		return
+	}
+	// The callback always receives the state in use, also for whisper_full
+	// on the context's default state.
+	n := int(C.whisper_full_n_segments_from_state(state))
+	for i := n - int(nNew); i < n; i++ {
+		ci := C.int(i)
+		fn(Segment{
+			Start:      time.Duration(C.whisper_full_get_segment_t0_from_state(state, ci)) * 10 * time.Millisecond,
+			End:        time.Duration(C.whisper_full_get_segment_t1_from_state(state, ci)) * 10 * time.Millisecond,
+			Text:       C.GoString(C.whisper_full_get_segment_text_from_state(state, ci)),
+			Confidence: segmentConfidence(ctx, state, ci),
+		})
+	}
+}
+
+// segmentConfidence returns how sure whisper is of segment i, from wctx's
+// default state or from state if it is non-nil: the geometric mean
+// probability of its text tokens, times the probability that there is
+// speech at all. It is 0 if the segment has no text tokens. Special tokens
+// (timestamps, end of text) are skipped.
+func segmentConfidence(wctx *C.struct_whisper_context, state *C.struct_whisper_state, i C.int) float64 {
+	eot := C.whisper_token_eot(wctx)
+	var n C.int
+	var noSpeech C.float
+	if state == nil {
+		n = C.whisper_full_n_tokens(wctx, i)
+		noSpeech = C.whisper_full_get_segment_no_speech_prob(wctx, i)
+	} else {
+		n = C.whisper_full_n_tokens_from_state(state, i)
+		noSpeech = C.whisper_full_get_segment_no_speech_prob_from_state(state, i)
+	}
+	var sum float64
+	count := 0
+	for j := C.int(0); j < n; j++ {
+		var td C.whisper_token_data
+		if state == nil {
+			td = C.whisper_full_get_token_data(wctx, i, j)
+		} else {
+			td = C.whisper_full_get_token_data_from_state(state, i, j)
+		}
+		if td.id >= eot {
+			continue
+		}
+		sum += float64(td.plog)
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return math.Exp(sum/float64(count)) * (1 - float64(noSpeech))
+}
+
 // WhisperModel wraps a whisper.cpp context loaded from a GGML model file.
-// It is NOT safe for concurrent use; callers must serialize access or use
-// separate model instances.
+// Its own methods are NOT safe for concurrent use; to transcribe several
+// inputs at once, give each worker its own WhisperState (see NewState).
 type WhisperModel struct {
 	ctx *C.struct_whisper_context
 }
@@ -86,28 +217,80 @@ func (m *WhisperModel) Close() {
 	}
 }
 
+// WhisperState is an independent decoding state on a shared WhisperModel.
+// Several states can transcribe concurrently while the model weights are
+// only loaded once; each state allocates its own KV cache and compute
+// buffers. A single state is NOT safe for concurrent use.
+type WhisperState struct {
+	model   *WhisperModel
+	state   *C.struct_whisper_state
+	threads int
+}
+
+// NewState allocates a decoding state that runs inference on the given
+// number of CPU threads. The caller must call Close() on the state before
+// closing the model.
+func (m *WhisperModel) NewState(threads int) (*WhisperState, error) {
+	if m.ctx == nil {
+		return nil, errors.New("whisper model is closed")
+	}
+	state := C.whisper_init_state(m.ctx)
+	if state == nil {
+		return nil, errors.New("failed to allocate whisper state")
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	return &WhisperState{model: m, state: state, threads: threads}, nil
+}
+
+// Close frees the state. It is safe to call Close multiple times.
+func (s *WhisperState) Close() {
+	if s.state != nil {
+		C.whisper_free_state(s.state)
+		s.state = nil
+	}
+}
+
 // Transcribe runs whisper inference on 16 kHz float32 PCM samples and returns
-// timestamped text segments.
-//
-// language should be an ISO-639-1 code (e.g. "en", "de") or "" for
-// auto-detection. onProgress, if non-nil, is called with percentage [0..100].
-func (m *WhisperModel) Transcribe(samples []float32, language string, onProgress func(int)) ([]Segment, error) {
+// timestamped text segments. See TranscribeOptions for the language and
+// callbacks. Cancelling ctx aborts inference and returns ctx.Err().
+func (m *WhisperModel) Transcribe(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
 	if m.ctx == nil {
 		return nil, errors.New("whisper model is closed")
 	}
+	return transcribe(ctx, m.ctx, nil, runtime.NumCPU(), samples, opts)
+}
+
+// Transcribe is WhisperModel.Transcribe using this state and its share of
+// CPU threads.
+func (s *WhisperState) Transcribe(ctx context.Context, samples []float32, opts TranscribeOptions) ([]Segment, error) {
+	if s.state == nil || s.model.ctx == nil {
+		return nil, errors.New("whisper state is closed")
+	}
+	return transcribe(ctx, s.model.ctx, s.state, s.threads, samples, opts)
+}
+
+// transcribe runs whisper_full on wctx's default state, or on state if it
+// is non-nil, with the given thread count.
+func transcribe(ctx context.Context, wctx *C.struct_whisper_context, state *C.struct_whisper_state, threads int, samples []float32, opts TranscribeOptions) ([]Segment, error) {
 	if len(samples) == 0 {
 		return nil, errors.New("no audio samples provided")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// 1. Create default params with greedy sampling strategy.
 	params := C.whisper_full_default_params(C.WHISPER_SAMPLING_GREEDY)
 
-	// 2. Thread count: use all available CPUs.
-	params.n_threads = C.int(runtime.NumCPU())
+	// 2. Thread count and initial temperature.
+	params.n_threads = C.int(threads)
+	params.temperature = C.float(opts.Temperature)
 
 	// 3. Language setting.
-	if language != "" {
-		clang := C.CString(language)
+	if opts.Language != "" {
+		clang := C.CString(opts.Language)
 		defer C.free(unsafe.Pointer(clang))
 		params.language = clang
 	} else {
@@ -121,33 +304,65 @@ func (m *WhisperModel) Transcribe(samples []float32, language string, onProgress
 	params.print_special = C.bool(false)
 	params.print_timestamps = C.bool(false)
 
-	// 5. Set progress callback if provided.
+	// 5. Set progress and segment callbacks if provided.
 	var cbID uintptr
-	if onProgress != nil {
-		cbID = registerProgress(onProgress)
+	if opts.OnProgress != nil {
+		cbID = registerProgress(opts.OnProgress)
 		defer unregisterProgress(cbID)
 		C.set_progress_callback(&params, unsafe.Pointer(cbID))
 	}
+	if opts.OnSegment != nil {
+		segID := registerSegment(opts.OnSegment)
+		defer unregisterSegment(segID)
+		C.set_new_segment_callback(&params, unsafe.Pointer(segID))
+	}
+
+	// 6. Abort when the context is cancelled.
+	abortID := registerAbort(ctx)
+	defer unregisterAbort(abortID)
+	C.set_abort_callback(&params, unsafe.Pointer(abortID))
 
-	// 6. Run transcription.
-	ret := C.whisper_full(m.ctx, params, (*C.float)(&samples[0]), C.int(len(samples)))
+	// 7. Run transcription.
+	var ret C.int
+	if state == nil {
+		ret = C.whisper_full(wctx, params, (*C.float)(&samples[0]), C.int(len(samples)))
+	} else {
+		ret = C.whisper_full_with_state(wctx, state, params, (*C.float)(&samples[0]), C.int(len(samples)))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if ret != 0 {
 		return nil, errors.New("whisper transcription failed")
 	}
 
-	// 7. Extract segments from the context.
-	nSegments := int(C.whisper_full_n_segments(m.ctx))
+	// 8. Extract segments from the context (or state).
+	var nSegments int
+	if state == nil {
+		nSegments = int(C.whisper_full_n_segments(wctx))
+	} else {
+		nSegments = int(C.whisper_full_n_segments_from_state(state))
+	}
 	segments := make([]Segment, 0, nSegments)
 	for i := 0; i < nSegments; i++ {
 		ci := C.int(i)
-		t0 := int64(C.whisper_full_get_segment_t0(m.ctx, ci)) // centiseconds (10 ms units)
-		t1 := int64(C.whisper_full_get_segment_t1(m.ctx, ci))
-		text := C.GoString(C.whisper_full_get_segment_text(m.ctx, ci))
+		var t0, t1 int64 // centiseconds (10 ms units)
+		var text string
+		if state == nil {
+			t0 = int64(C.whisper_full_get_segment_t0(wctx, ci))
+			t1 = int64(C.whisper_full_get_segment_t1(wctx, ci))
+			text = C.GoString(C.whisper_full_get_segment_text(wctx, ci))
+		} else {
+			t0 = int64(C.whisper_full_get_segment_t0_from_state(state, ci))
+			t1 = int64(C.whisper_full_get_segment_t1_from_state(state, ci))
+			text = C.GoString(C.whisper_full_get_segment_text_from_state(state, ci))
+		}
 
 		segments = append(segments, Segment{
-			Start: time.Duration(t0) * 10 * time.Millisecond,
-			End:   time.Duration(t1) * 10 * time.Millisecond,
-			Text:  text,
+			Start:      time.Duration(t0) * 10 * time.Millisecond,
+			End:        time.Duration(t1) * 10 * time.Millisecond,
+			Text:       text,
+			Confidence: segmentConfidence(wctx, state, ci),
 		})
 	}
 